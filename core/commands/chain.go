@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	errChainNotAvailable = errors.New("chain is not available")
+)
+
+type ChainEndpointList struct {
+	List []chain.EndpointStatus
+}
+
+// ChainCmd is the 'ant chain' command
+var ChainCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Inspect the chain RPC endpoints.",
+		ShortDescription: `Inspect the chain RPC endpoints.`,
+	},
+	Options: []cmds.Option{},
+	Subcommands: map[string]*cmds.Command{
+		"endpoints": ChainEndpointsCmd,
+	},
+}
+
+var ChainEndpointsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the health of the chain RPC endpoints",
+		ShortDescription: `
+Shows the endpoints of Ant.Chain.Endpoint and Ant.Chain.Endpoints with their
+chain id, head block, head age, latency and last error. The endpoints are
+checked every 30 seconds; reads go to the healthy endpoint with the lowest
+latency and fail over to the next one. The endpoint transactions are
+submitted through is marked with a '*'.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.Chain == nil {
+			return errChainNotAvailable
+		}
+		return cmds.EmitOnce(res, &ChainEndpointList{List: nd.Chain.Endpoints()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ChainEndpointList) error {
+			now := time.Now()
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", "", "URL", "STATE", "CHAIN", "HEAD", "HEAD AGE", "LATENCY", "FAILURES", "LAST ERROR")
+			for _, e := range out.List {
+				submitter := ""
+				if e.Submitter {
+					submitter = "*"
+				}
+				state := "down"
+				switch {
+				case e.LastCheck.IsZero():
+					state = "unchecked"
+				case e.Healthy && now.Before(e.BackoffUntil):
+					state = fmt.Sprintf("rate limited until %s", e.BackoffUntil.Format(time.RFC3339))
+				case e.Healthy:
+					state = "up"
+				}
+				chainID, head, headAge := "-", "-", "-"
+				if e.ChainID != "" {
+					chainID = e.ChainID
+				}
+				if !e.HeadTime.IsZero() {
+					head = fmt.Sprint(e.BlockNumber)
+					headAge = now.Sub(e.HeadTime).Round(time.Second).String()
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t\n", submitter, e.URL, state, chainID, head, headAge,
+					e.Latency.Round(time.Millisecond), e.Failures, e.LastError)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: ChainEndpointList{},
+}
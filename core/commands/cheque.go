@@ -3,16 +3,34 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/types"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	"os"
+	"text/tabwriter"
+	"time"
 )
 
 type Cheques struct {
 	List []iface.Cheque
 }
 
+type ChequeHistoryEntry struct {
+	Chequebook       string
+	From             string
+	ReceivedAt       time.Time
+	CumulativePayout string
+	CumulativeReward string
+}
+
+type ChequeHistory struct {
+	List []ChequeHistoryEntry
+}
+
+
 // ChequeCmd is the 'ipfs cheque' command
 var ChequeCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
@@ -21,10 +39,13 @@ var ChequeCmd = &cmds.Command{
 	},
 	Options: []cmds.Option{},
 	Subcommands: map[string]*cmds.Command{
-		"ls":         ChequeListCmd,
-		"get":        ChequeGetCmd,
-		"cashout":    ChequeCashOutCmd,
-		"cashoutall": ChequeCashOutAllCmd,
+		"ls":          ChequeListCmd,
+		"get":         ChequeGetCmd,
+		"cashout":     ChequeCashOutCmd,
+		"cashoutall":  ChequeCashOutAllCmd,
+		"history":     ChequeHistoryCmd,
+		"autocashout": ChequeAutoCashOutCmd,
+		"cashouts":    ChequeCashOutsCmd,
 	},
 }
 
@@ -92,65 +113,271 @@ var ChequeGetCmd = &cmds.Command{
 	Type: iface.Cheque{},
 }
 
+const chequeWaitOptionName = "wait"
+
 var ChequeCashOutCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
-		Tagline:          "CashOut cheques",
-		ShortDescription: ``,
+		Tagline: "CashOut cheques",
+		ShortDescription: `
+Cashes out the uncashed amount of the chequebook and prints the hash of the
+cash-out transaction. With --wait the command waits until the transaction
+was mined, reverted or dropped and prints its final status.
+`,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("chequebook", true, false, "chequebook contract address"),
 	},
-	Options: []cmds.Option{},
+	Options: []cmds.Option{
+		cmds.BoolOption(chequeWaitOptionName, "w", "Wait for the final status of the cash-out transaction."),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-		api, err := cmdenv.GetApi(env, req)
+		api, err := cmdenv.GetChequeApi(env, req)
 		if err != nil {
-			res.Emit(&stringOutput{Str: err.Error()})
 			return err
 		}
-		chequebook := req.Arguments[0]
-		err = api.Cheque().CashOut(req.Context, chequebook)
+		cashOut, err := api.CashOutCheque(req.Context, req.Arguments[0])
 		if err != nil {
-			res.Emit(&stringOutput{Str: err.Error()})
 			return err
 		}
-		return res.Emit(&stringOutput{Str: "Ok"})
+		wait, _ := req.Options[chequeWaitOptionName].(bool)
+		if wait {
+			cashOut, err = api.WaitCashOut(req.Context, cashOut.TxHash)
+			if err != nil {
+				return err
+			}
+		}
+		return res.Emit(&cashOut)
 	},
 	PostRun: cmds.PostRunMap{
 		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
-			v, _ := res.Next()
-			fmt.Fprintf(os.Stdout, "%s\n", v.(*stringOutput).Str)
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			record, ok := v.(*mineiface.CashOut)
+			if !ok {
+				data, _ := json.MarshalIndent(v, " ", " ")
+				fmt.Fprintf(os.Stdout, "%s\n", string(data))
+				return nil
+			}
+			fmt.Fprintf(os.Stdout, "tx: %s\nstatus: %s\n", record.TxHash, record.Status)
+			if record.Status != mineiface.CashOutStatusPending && record.Status != mineiface.CashOutStatusDropped {
+				fmt.Fprintf(os.Stdout, "block: %d\ngas used: %d\n", record.BlockNumber, record.GasUsed)
+			}
+			if record.RevertReason != "" {
+				fmt.Fprintf(os.Stdout, "revert reason: %s\n", record.RevertReason)
+			}
 			return nil
 		},
 	},
-	Type: stringOutput{},
+	Type: mineiface.CashOut{},
+}
+
+type CashOutAllOutput struct {
+	Results []mineiface.CashOutResult
 }
 
 var ChequeCashOutAllCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
-		Tagline:          "CashOut all cheques",
-		ShortDescription: ``,
+		Tagline: "CashOut all cheques",
+		ShortDescription: `
+Tries to cash out every chequebook with an uncashed amount and prints the
+result for each chequebook: the submitted transaction, the reason it was
+skipped, or the error it failed with. Use --enc=json for JSON output.
+`,
 	},
 	Arguments: []cmds.Argument{},
 	Options:   []cmds.Option{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-		api, err := cmdenv.GetApi(env, req)
+		api, err := cmdenv.GetChequeApi(env, req)
+		if err != nil {
+			return err
+		}
+		results, err := api.CashOutAllResults(req.Context)
 		if err != nil {
-			res.Emit(&stringOutput{Str: err.Error()})
 			return err
 		}
-		err = api.Cheque().CashOutAll(req.Context)
+		return cmds.EmitOnce(res, &CashOutAllOutput{Results: results})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CashOutAllOutput) error {
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", "CHEQUEBOOK", "RESULT", "AMOUNT", "TX", "REASON")
+			for _, r := range out.Results {
+				amount := ""
+				if r.Amount != "" {
+					amount = types.AntzFromRawString(r.Amount).String()
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", r.Chequebook, r.Status, amount, r.TxHash, r.Reason)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: CashOutAllOutput{},
+}
+
+var ChequeHistoryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List every accepted cheque",
+		ShortDescription: `
+Lists every cheque accepted from the queens, with the time it was received
+and the peer that sent it. If no chequebook is given, the history of all
+chequebooks is listed.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("chequebook", false, false, "chequebook contract address"),
+	},
+	Options: []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetChequeApi(env, req)
+		if err != nil {
+			return err
+		}
+		chequebook := ""
+		if len(req.Arguments) > 0 {
+			chequebook = req.Arguments[0]
+		}
+		records, err := api.History(req.Context, chequebook)
 		if err != nil {
-			res.Emit(&stringOutput{Str: err.Error()})
 			return err
 		}
-		return res.Emit(&stringOutput{Str: "Ok"})
+		history := ChequeHistory{List: make([]ChequeHistoryEntry, 0, len(records))}
+		for _, record := range records {
+			history.List = append(history.List, ChequeHistoryEntry{
+				Chequebook:       record.Chequebook,
+				From:             record.From,
+				ReceivedAt:       record.ReceivedAt,
+				CumulativePayout: types.AntzFromRawString(record.CumulativePayout).String(),
+				CumulativeReward: types.AntzFromRawString(record.CumulativeReward).String(),
+			})
+		}
+		return res.Emit(&history)
 	},
 	PostRun: cmds.PostRunMap{
 		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
-			v, _ := res.Next()
-			fmt.Fprintf(os.Stdout, "%s\n", v.(*stringOutput).Str)
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			history, ok := v.(*ChequeHistory)
+			if !ok {
+				data, _ := json.MarshalIndent(v, " ", " ")
+				fmt.Fprintf(os.Stdout, "%s\n", string(data))
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", "RECEIVED", "CHEQUEBOOK", "FROM", "PAYOUT", "REWARD")
+			for _, entry := range history.List {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", entry.ReceivedAt.Format(time.RFC3339),
+					entry.Chequebook, entry.From, entry.CumulativePayout, entry.CumulativeReward)
+			}
+			w.Flush()
+			return nil
+		},
+	},
+	Type: ChequeHistory{},
+}
+
+var ChequeAutoCashOutCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the automatic cash-out policy and its decisions",
+		ShortDescription: `
+Shows the automatic cash-out policy configured under Ant.CashOut, and the
+decisions taken by the cash-out loop with the resulting transaction hashes.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetChequeApi(env, req)
+		if err != nil {
+			return err
+		}
+		out, err := api.AutoCashOut(req.Context)
+		if err != nil {
+			return err
+		}
+		return res.Emit(&out)
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			out, ok := v.(*mineiface.AutoCashOut)
+			if !ok {
+				data, _ := json.MarshalIndent(v, " ", " ")
+				fmt.Fprintf(os.Stdout, "%s\n", string(data))
+				return nil
+			}
+			p := out.Policy
+			fmt.Fprintf(os.Stdout, "enabled: %v, threshold: %v ANTZ, interval: %v, max gas price: %v gwei, min balance: %v BNB\n",
+				p.Enabled, p.Threshold, p.Interval, p.MaxGasPrice, p.MinBNBBalance)
+			w := tabwriter.NewWriter(os.Stdout, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", "TIME", "ACTION", "CHEQUEBOOK", "AMOUNT", "TX", "REASON")
+			for _, d := range out.Decisions {
+				amount := ""
+				if d.Amount != "" {
+					amount = types.AntzFromRawString(d.Amount).String()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", time.Unix(0, d.Time).Format(time.RFC3339),
+					d.Action, d.Chequebook, amount, d.TxHash, d.Reason)
+			}
+			w.Flush()
+			return nil
+		},
+	},
+	Type: mineiface.AutoCashOut{},
+}
+
+type CashOuts struct {
+	List []mineiface.CashOut
+}
+
+var ChequeCashOutsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List past and in-flight cash-outs",
+		ShortDescription: `
+Lists the cash-out transactions sent by this node, newest first, with their
+amount, gas used and final status (pending, mined, reverted or dropped).
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetChequeApi(env, req)
+		if err != nil {
+			return err
+		}
+		list, err := api.CashOuts(req.Context)
+		if err != nil {
+			return err
+		}
+		return res.Emit(&CashOuts{List: list})
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			cashOuts, ok := v.(*CashOuts)
+			if !ok {
+				data, _ := json.MarshalIndent(v, " ", " ")
+				fmt.Fprintf(os.Stdout, "%s\n", string(data))
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", "CREATED", "STATUS", "CHEQUEBOOK", "AMOUNT", "GAS USED", "TX", "REVERT REASON")
+			for _, r := range cashOuts.List {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t\n", time.Unix(r.Created, 0).Format(time.RFC3339),
+					r.Status, r.Chequebook, types.AntzFromRawString(r.Amount).String(), r.GasUsed, r.TxHash, r.RevertReason)
+			}
+			w.Flush()
 			return nil
 		},
 	},
-	Type: stringOutput{},
+	Type: CashOuts{},
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	config "github.com/ipfs/go-ipfs-config"
@@ -52,6 +53,33 @@ func GetApi(env cmds.Environment, req *cmds.Request) (coreiface.CoreAPI, error)
 	return api, nil
 }
 
+// GetMineApi extracts the mine sections of the CoreAPI from the environment.
+func GetMineApi(env cmds.Environment, req *cmds.Request) (mineiface.MineAPI, error) {
+	api, err := GetApi(env, req)
+	if err != nil {
+		return nil, err
+	}
+	mineApi, ok := api.(mineiface.MineAPI)
+	if !ok {
+		return nil, fmt.Errorf("expected api to implement the mine api, got %T", api)
+	}
+	return mineApi, nil
+}
+
+// GetChequeApi extracts the cheque section of the CoreAPI, with its mine
+// extensions, from the environment.
+func GetChequeApi(env cmds.Environment, req *cmds.Request) (mineiface.ChequeAPI, error) {
+	api, err := GetApi(env, req)
+	if err != nil {
+		return nil, err
+	}
+	chequeApi, ok := api.Cheque().(mineiface.ChequeAPI)
+	if !ok {
+		return nil, fmt.Errorf("expected cheque api to implement the mine cheque api, got %T", api.Cheque())
+	}
+	return chequeApi, nil
+}
+
 // GetConfig extracts the config from the environment.
 func GetConfig(env cmds.Environment) (*config.Config, error) {
 	ctx, ok := env.(*commands.Context)
@@ -0,0 +1,417 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/mineservice"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type MineBlocks struct {
+	List []*mineservice.BlockRecord
+}
+
+type MineWebhookDeadLetters struct {
+	List []*mineservice.WebhookDeadLetter
+}
+
+type MineScrub struct {
+	State      *mineservice.ScrubState
+	Quarantine []*mineservice.QuarantineRecord
+}
+
+var errMineServiceNotAvailable = errors.New("mine service is not running")
+
+// MineCmd is the 'ant mine' command
+var MineCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Inspect the mine service.",
+		ShortDescription: `Inspect the mine service.`,
+	},
+	Options: []cmds.Option{},
+	Subcommands: map[string]*cmds.Command{
+		"blocks":  MineBlocksCmd,
+		"events":  MineEventsCmd,
+		"scrub":   MineScrubCmd,
+		"webhook": MineWebhookCmd,
+	},
+}
+
+var MineBlocksCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the blocks stored for the queens.",
+		ShortDescription: `
+Inspect the blocks pushed by the queens or migrated from other ants, with
+their size, source and arrival time.
+`,
+	},
+	Options: []cmds.Option{},
+	Subcommands: map[string]*cmds.Command{
+		"ls":      MineBlocksListCmd,
+		"stat":    MineBlocksStatCmd,
+		"summary": MineBlocksSummaryCmd,
+	},
+}
+
+var MineBlocksListCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "List the blocks stored for the queens",
+		ShortDescription: ``,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		list, err := nd.MineService.Blocks()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &MineBlocks{List: list})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MineBlocks) error {
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", "CID", "SIZE", "ORIGIN", "SOURCE", "ARRIVED")
+			for _, r := range out.List {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t\n", r.Cid, r.Size, r.Origin, r.Source,
+					time.Unix(r.ArrivedAt, 0).Format(time.RFC3339))
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: MineBlocks{},
+}
+
+var MineBlocksStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Show the index record of a block stored for the queens",
+		ShortDescription: ``,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, false, "cid of the block"),
+	},
+	Options: []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		bcid, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		record, err := nd.MineService.Block(bcid)
+		if err == datastore.ErrNotFound {
+			return errors.New("block is not stored for the queens")
+		}
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, record)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *mineservice.BlockRecord) error {
+			fmt.Fprintf(w, "cid: %s\n", out.Cid)
+			fmt.Fprintf(w, "size: %d\n", out.Size)
+			fmt.Fprintf(w, "origin: %s\n", out.Origin)
+			fmt.Fprintf(w, "source: %s\n", out.Source)
+			fmt.Fprintf(w, "arrived: %s\n", time.Unix(out.ArrivedAt, 0).Format(time.RFC3339))
+			return nil
+		}),
+	},
+	Type: mineservice.BlockRecord{},
+}
+
+var MineBlocksSummaryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Total the blocks stored for the queens by origin and by day",
+		ShortDescription: ``,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		summary, err := nd.MineService.BlockSummary()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, summary)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *mineservice.BlockSummary) error {
+			fmt.Fprintf(w, "total: %d blocks, %s\n\n", out.Total.Blocks, humanize.Bytes(out.Total.Bytes))
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\n", "ORIGIN", "BLOCKS", "SIZE")
+			origins := make([]string, 0, len(out.ByOrigin))
+			for origin := range out.ByOrigin {
+				origins = append(origins, origin)
+			}
+			sort.Strings(origins)
+			for _, origin := range origins {
+				t := out.ByOrigin[origin]
+				fmt.Fprintf(tw, "%s\t%d\t%s\t\n", origin, t.Blocks, humanize.Bytes(t.Bytes))
+			}
+			fmt.Fprintf(tw, "\t\t\t\n%s\t%s\t%s\t\n", "DAY", "BLOCKS", "SIZE")
+			for _, t := range out.ByDay {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t\n", t.Day, t.Blocks, humanize.Bytes(t.Bytes))
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: mineservice.BlockSummary{},
+}
+
+var MineScrubCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the progress of the scrubber and the quarantined blocks",
+		ShortDescription: `
+The scrubber re-hashes the blocks stored for the queens. Corrupt or missing
+blocks are fetched again, those which cannot be fetched are quarantined and
+reported to the queen.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		state, err := nd.MineService.ScrubState()
+		if err != nil {
+			return err
+		}
+		quarantine, err := nd.MineService.Quarantine()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &MineScrub{State: state, Quarantine: quarantine})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MineScrub) error {
+			st := out.State
+			fmt.Fprintf(w, "pass: %d\n", st.Pass)
+			if st.Cursor != "" {
+				fmt.Fprintf(w, "cursor: %s\n", st.Cursor)
+			}
+			if st.LastPassEnded > 0 {
+				fmt.Fprintf(w, "last pass ended: %s\n", time.Unix(0, st.LastPassEnded).Format(time.RFC3339))
+			}
+			fmt.Fprintf(w, "checked: %d, corrupt: %d, recovered: %d, lost: %d\n", st.Checked, st.Corrupt,
+				st.Recovered, st.Lost)
+			if len(out.Quarantine) == 0 {
+				return nil
+			}
+			fmt.Fprintln(w)
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", "CID", "REASON", "DETECTED", "REPORTED")
+			for _, r := range out.Quarantine {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t\n", r.Cid, r.Reason,
+					time.Unix(r.DetectedAt, 0).Format(time.RFC3339), r.Reported)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: MineScrub{},
+}
+
+const (
+	mineEventsFollowOptionName = "follow"
+	mineEventsTypeOptionName   = "type"
+	mineEventsSinceOptionName  = "since"
+)
+
+var MineEventsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the events of the mine service",
+		ShortDescription: `
+Shows the recent events of the mine service: pushed and migrated blocks and
+DAGs, received and rejected cheques, submitted and finished cash-outs, and
+the alerts on the wallet balance, the pledge, the queens and the quota.
+With --follow the new events are streamed as they happen.
+`,
+		LongDescription: `
+Shows the recent events of the mine service: pushed and migrated blocks and
+DAGs, received and rejected cheques, submitted and finished cash-outs, and
+the alerts on the wallet balance, the pledge, the queens and the quota.
+With --follow the new events are streamed as they happen.
+
+Event types: ` + strings.Join(mineservice.EventTypes, ", ") + `
+
+Events are numbered in order. The recent events are kept in memory, use
+--since with the last sequence number received to resume a stream without
+missing the events retained in between. A stream which lags behind is
+closed by the daemon.
+
+The events are output as one JSON object per line with --enc=json.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options: []cmds.Option{
+		cmds.BoolOption(mineEventsFollowOptionName, "f", "Stream the new events."),
+		cmds.StringOption(mineEventsTypeOptionName, "t", "Comma separated event types to show, all types by default."),
+		cmds.Uint64Option(mineEventsSinceOptionName, "Show the retained events after this sequence number.").WithDefault(uint64(0)),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetMineApi(env, req)
+		if err != nil {
+			return err
+		}
+		follow, _ := req.Options[mineEventsFollowOptionName].(bool)
+		since, _ := req.Options[mineEventsSinceOptionName].(uint64)
+		var types []string
+		if t, _ := req.Options[mineEventsTypeOptionName].(string); t != "" {
+			types = strings.Split(t, ",")
+		}
+
+		if !follow {
+			events, err := api.Event().List(req.Context, since, types...)
+			if err != nil {
+				return err
+			}
+			for i := range events {
+				if err := res.Emit(&events[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		events, err := api.Event().Subscribe(req.Context, since, types...)
+		if err != nil {
+			return err
+		}
+		if f, ok := res.(http.Flusher); ok {
+			f.Flush()
+		}
+		last := since
+		for event := range events {
+			event := event
+			if err := res.Emit(&event); err != nil {
+				return err
+			}
+			last = event.Seq
+		}
+		if req.Context.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("the event stream fell behind, resume with --%s=%d", mineEventsSinceOptionName, last)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *mineiface.Event) error {
+			_, err := fmt.Fprintf(w, "%d %s %s %s\n", out.Seq, out.Time.Format(time.RFC3339), out.Type, out.Data)
+			return err
+		}),
+	},
+	Type: mineiface.Event{},
+}
+
+var MineWebhookCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the webhook notifications",
+		ShortDescription: `
+The events of Ant.Webhook.Events are POSTed as signed JSON to
+Ant.Webhook.URL. A payload is retried with backoff and recorded as a dead
+letter once its attempts are exhausted.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"test":         MineWebhookTestCmd,
+		"dead-letters": MineWebhookDeadLettersCmd,
+	},
+}
+
+var MineWebhookTestCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Send a test payload to the webhook",
+		ShortDescription: `
+Sends a payload of type webhook-test to the webhook once, without retries,
+and fails if the webhook does not answer with a 2xx status.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		if err := nd.MineService.TestWebhook(req.Context); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &MessageOutput{Message: "test payload delivered\n"})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
+			_, err := fmt.Fprint(w, out.Message)
+			return err
+		}),
+	},
+	Type: MessageOutput{},
+}
+
+var MineWebhookDeadLettersCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the payloads which could not be delivered to the webhook",
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		list, err := nd.MineService.WebhookDeadLetters()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &MineWebhookDeadLetters{List: list})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MineWebhookDeadLetters) error {
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", "SEQ", "TYPE", "FAILED", "ATTEMPTS", "ERROR")
+			for _, l := range out.List {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t\n", l.Seq, l.Type,
+					time.Unix(l.Failed, 0).Format(time.RFC3339), l.Attempts, l.LastError)
+			}
+			return tw.Flush()
+		}),
+	},
+	Type: MineWebhookDeadLetters{},
+}
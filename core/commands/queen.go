@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"github.com/ethereum/go-ethereum/common"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/mineservice"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type RosterQueen struct {
+	ID         string
+	Chequebook string
+	Addrs      []string
+}
+
+type QueenRosterOutput struct {
+	RosterSigner string
+	Version      uint64
+	Signer       string
+	From         string
+	ReceivedAt   time.Time
+	Queens       []RosterQueen
+}
+
+type QueenList struct {
+	List []mineiface.Queen
+}
+
+// QueenCmd is the 'ant queen' command
+var QueenCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Inspect the queens.",
+		ShortDescription: `Inspect the queens.`,
+	},
+	Options: []cmds.Option{},
+	Subcommands: map[string]*cmds.Command{
+		"ls":     QueenListCmd,
+		"ping":   QueenPingCmd,
+		"roster": QueenRosterCmd,
+	},
+}
+
+var QueenListCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the known queens",
+		ShortDescription: `
+Lists the active and bootstrap queens with their addresses, connection state
+and ping health. Active queens come from the roster, or from an unsigned queen
+list when no roster key is configured. Queens failing their pings are backed
+off exponentially and are not used until their back-off expired. Reports are
+sent to the healthiest queen, the queen the last report was sent to is marked
+with a '*'.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetMineApi(env, req)
+		if err != nil {
+			return err
+		}
+		queens, err := api.Queen().List(req.Context)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &QueenList{List: queens})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *QueenList) error {
+			now := time.Now()
+			tw := tabwriter.NewWriter(w, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", "", "ID", "SOURCE", "STATE", "HEALTH",
+				"SUCCESS", "FAILURES", "LAST PING", "LAST PONG", "RTT", "ADDRS")
+			for _, q := range out.List {
+				reported := ""
+				if q.LastReported {
+					reported = "*"
+				}
+				health := "up"
+				if now.Before(q.BackoffUntil) {
+					health = fmt.Sprintf("down until %s", q.BackoffUntil.Format(time.RFC3339))
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.0f%%\t%d/%d\t%s\t%s\t%s\t%s\t\n", reported, q.ID, q.Source,
+					q.Connectedness, health, q.SuccessRate*100, q.Failures, q.Pings, formatQueenTime(q.LastPing),
+					formatQueenTime(q.LastPong), q.RTT.Round(time.Millisecond), strings.Join(q.Addrs, ","))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			for _, q := range out.List {
+				for _, e := range q.RecentErrors {
+					fmt.Fprintf(w, "%s %s: %s\n", e.Time.Format(time.RFC3339), q.ID, e.Error)
+				}
+			}
+			return nil
+		}),
+	},
+	Type: QueenList{},
+}
+
+var QueenPingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Ping a queen now",
+		ShortDescription: `
+Pings the queen immediately instead of waiting for the next ping round, and
+records the outcome in its health.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, false, "peer ID of the queen"),
+	},
+	Options: []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetMineApi(env, req)
+		if err != nil {
+			return err
+		}
+		id, err := peer.Decode(req.Arguments[0])
+		if err != nil {
+			return fmt.Errorf("invalid peer ID %q: %w", req.Arguments[0], err)
+		}
+		ping, err := api.Queen().Ping(req.Context, id)
+		if err != nil {
+			return err
+		}
+		if !ping.Success {
+			return fmt.Errorf("ping to %s failed: %s", ping.ID, ping.Error)
+		}
+		return cmds.EmitOnce(res, &ping)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *mineiface.QueenPing) error {
+			_, err := fmt.Fprintf(w, "pong from %s in %s\n", out.ID, out.RTT.Round(time.Millisecond))
+			return err
+		}),
+	},
+	Type: mineiface.QueenPing{},
+}
+
+func formatQueenTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+var QueenRosterCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the current queen roster",
+		ShortDescription: `
+Shows the latest valid queen roster with its version, the address which
+signed it and the peer it was received from. Rosters must be signed by the
+roster key configured under Ant.Roster.Signer.
+`,
+	},
+	Arguments: []cmds.Argument{},
+	Options:   []cmds.Option{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.MineService == nil {
+			return errMineServiceNotAvailable
+		}
+		record, rosterSigner, err := nd.MineService.QueenRoster()
+		if err != nil && err != mineservice.ErrNoQueenRoster {
+			return err
+		}
+		out := &QueenRosterOutput{Queens: []RosterQueen{}}
+		if rosterSigner != (common.Address{}) {
+			out.RosterSigner = rosterSigner.Hex()
+		}
+		if record != nil {
+			out.Version = record.Roster.Version
+			out.Signer = record.Signer
+			out.From = record.From
+			out.ReceivedAt = time.Unix(record.ReceivedAt, 0)
+			for _, q := range record.Roster.Queens {
+				out.Queens = append(out.Queens, RosterQueen{
+					ID:         peer.ID(q.Id).String(),
+					Chequebook: q.Chequebook,
+					Addrs:      q.Addrs,
+				})
+			}
+		}
+		return res.Emit(out)
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			out, ok := v.(*QueenRosterOutput)
+			if !ok {
+				data, _ := json.MarshalIndent(v, " ", " ")
+				fmt.Fprintf(os.Stdout, "%s\n", string(data))
+				return nil
+			}
+			if out.RosterSigner == "" {
+				fmt.Fprintf(os.Stdout, "roster key: not configured, unsigned queen lists are accepted\n")
+			} else {
+				fmt.Fprintf(os.Stdout, "roster key: %s\n", out.RosterSigner)
+			}
+			if out.Signer == "" {
+				fmt.Fprintf(os.Stdout, "no roster received yet\n")
+				return nil
+			}
+			fmt.Fprintf(os.Stdout, "version: %d, signer: %s, from: %s, received: %s\n",
+				out.Version, out.Signer, out.From, out.ReceivedAt.Format(time.RFC3339))
+			w := tabwriter.NewWriter(os.Stdout, 15, 4, 1, ' ', 0)
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", "ID", "CHEQUEBOOK", "ADDRS")
+			for _, q := range out.Queens {
+				fmt.Fprintf(w, "%s\t%s\t%s\t\n", q.ID, q.Chequebook, strings.Join(q.Addrs, ","))
+			}
+			w.Flush()
+			return nil
+		},
+	},
+	Type: QueenRosterOutput{},
+}
@@ -45,6 +45,9 @@ TOOL COMMANDS
 MINING COMMANDS
   cheque        Interact with cheques
   wallet        Interact with the wallet
+  chain         Inspect the chain RPC endpoints
+  queen         Inspect the queens
+  mine          Inspect the mine service
 
 Use 'ant <command> --help' to learn more about each command.
 
@@ -124,6 +127,9 @@ var rootSubcommands = map[string]*cmds.Command{
 	//"cid":       CidCmd,
 	"cheque": ChequeCmd,
 	"wallet": WalletCmd,
+	"chain":  ChainCmd,
+	"queen":  QueenCmd,
+	"mine":   MineCmd,
 }
 
 // RootRO is the readonly version of Root
@@ -173,6 +179,9 @@ var rootROSubcommands = map[string]*cmds.Command{
 	//"resolve": ResolveCmd,
 	"cheque": ChequeCmd,
 	"wallet": WalletCmd,
+	"chain":  ChainCmd,
+	"queen":  QueenCmd,
+	"mine":   MineCmd,
 }
 
 func init() {
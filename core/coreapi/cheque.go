@@ -2,11 +2,21 @@ package coreapi
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/mineservice"
 	"github.com/ipfs/interface-go-ipfs-core"
 )
 
+var errChequeManagerNotRunning = errors.New("cheque manager is not running")
+
 type ChequeAPI CoreAPI
 
+var _ mineiface.ChequeAPI = (*ChequeAPI)(nil)
+
 func (c *ChequeAPI) Get(ctx context.Context, chequebook string) (iface.Cheque, error) {
 	return c.chequeManger.GetCheque(ctx, chequebook)
 }
@@ -22,3 +32,134 @@ func (c *ChequeAPI) CashOut(ctx context.Context, chequebook string) error {
 func (c *ChequeAPI) CashOutAll(ctx context.Context) error {
 	return c.chequeManger.CashOutAll(ctx)
 }
+
+func (c *ChequeAPI) History(ctx context.Context, chequebook string) ([]mineiface.ChequeRecord, error) {
+	if c.chequeManger == nil {
+		return nil, errChequeManagerNotRunning
+	}
+	records, err := c.chequeManger.GetHistory(ctx, chequebook)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]mineiface.ChequeRecord, 0, len(records))
+	for _, r := range records {
+		history = append(history, mineiface.ChequeRecord{
+			Chequebook:       r.Cheque.Chequebook,
+			From:             r.From,
+			ReceivedAt:       time.Unix(0, r.ReceivedAt),
+			CumulativePayout: r.Cheque.CumulativePayout,
+			CumulativeReward: r.Cheque.CumulativeReward,
+		})
+	}
+	return history, nil
+}
+
+func (c *ChequeAPI) CashOutCheque(ctx context.Context, chequebook string) (mineiface.CashOut, error) {
+	if c.chequeManger == nil {
+		return mineiface.CashOut{}, errChequeManagerNotRunning
+	}
+	txHash, err := c.chequeManger.CashOutCheque(ctx, chequebook)
+	if err != nil {
+		return mineiface.CashOut{}, err
+	}
+	return mineiface.CashOut{
+		TxHash:     txHash.Hex(),
+		Chequebook: chequebook,
+		Status:     mineiface.CashOutStatusPending,
+	}, nil
+}
+
+func (c *ChequeAPI) WaitCashOut(ctx context.Context, txHash string) (mineiface.CashOut, error) {
+	if c.chequeManger == nil {
+		return mineiface.CashOut{}, errChequeManagerNotRunning
+	}
+	record, err := c.chequeManger.WaitCashOut(ctx, common.HexToHash(txHash))
+	if err != nil {
+		return mineiface.CashOut{}, err
+	}
+	return convertCashOut(record), nil
+}
+
+func (c *ChequeAPI) CashOutAllResults(ctx context.Context) ([]mineiface.CashOutResult, error) {
+	if c.chequeManger == nil {
+		return nil, errChequeManagerNotRunning
+	}
+	results, err := c.chequeManger.CashOutAllResults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]mineiface.CashOutResult, 0, len(results))
+	for _, r := range results {
+		ret = append(ret, mineiface.CashOutResult{
+			Chequebook: r.Chequebook,
+			Status:     r.Status,
+			Amount:     r.Amount,
+			TxHash:     r.TxHash,
+			Reason:     r.Reason,
+		})
+	}
+	return ret, nil
+}
+
+func (c *ChequeAPI) CashOuts(ctx context.Context) ([]mineiface.CashOut, error) {
+	if c.chequeManger == nil {
+		return nil, errChequeManagerNotRunning
+	}
+	records, err := c.chequeManger.GetCashOuts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cashOuts := make([]mineiface.CashOut, 0, len(records))
+	for _, r := range records {
+		cashOuts = append(cashOuts, convertCashOut(r))
+	}
+	return cashOuts, nil
+}
+
+func (c *ChequeAPI) AutoCashOut(ctx context.Context) (mineiface.AutoCashOut, error) {
+	if c.mineService == nil {
+		return mineiface.AutoCashOut{}, errMineServiceNotRunning
+	}
+	decisions, err := c.mineService.CashOutDecisions()
+	if err != nil {
+		return mineiface.AutoCashOut{}, err
+	}
+	policy := c.mineService.CashOutPolicy()
+	out := mineiface.AutoCashOut{
+		Policy: mineiface.CashOutPolicy{
+			Enabled:       policy.Enabled,
+			Threshold:     policy.Threshold,
+			Interval:      policy.Interval,
+			MaxGasPrice:   policy.MaxGasPrice,
+			MinBNBBalance: policy.MinBNBBalance,
+		},
+		Decisions: make([]mineiface.CashOutDecision, 0, len(decisions)),
+	}
+	for _, d := range decisions {
+		out.Decisions = append(out.Decisions, mineiface.CashOutDecision{
+			Seq:        d.Seq,
+			Time:       d.Time,
+			Chequebook: d.Chequebook,
+			Action:     d.Action,
+			Reason:     d.Reason,
+			Amount:     d.Amount,
+			TxHash:     d.TxHash,
+		})
+	}
+	return out, nil
+}
+
+func convertCashOut(r *mineservice.CashOutRecord) mineiface.CashOut {
+	return mineiface.CashOut{
+		TxHash:           r.TxHash,
+		Chequebook:       r.Chequebook,
+		Amount:           r.Amount,
+		CumulativePayout: r.CumulativePayout,
+		Status:           r.Status,
+		GasUsed:          r.GasUsed,
+		BlockNumber:      r.BlockNumber,
+		RevertReason:     r.RevertReason,
+		Created:          r.Created,
+		Updated:          r.Updated,
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
 	"github.com/ipfs/go-ipfs/core/mine/mineservice"
 	"github.com/ipfs/go-ipfs/core/mine/wallet"
 
@@ -76,6 +77,8 @@ type CoreAPI struct {
 
 	chequeManger *mineservice.ChequeManager
 
+	mineService *mineservice.MineService
+
 	chain chain.Chain
 
 	wallet wallet.Wallet
@@ -166,6 +169,16 @@ func (api *CoreAPI) Chain() coreiface.ChainAPI {
 	return (*ChainAPI)(api)
 }
 
+// Queen returns the QueenAPI interface implementation backed by the go-ipfs node
+func (api *CoreAPI) Queen() mineiface.QueenAPI {
+	return (*QueenAPI)(api)
+}
+
+// Event returns the EventAPI interface implementation backed by the go-ipfs node
+func (api *CoreAPI) Event() mineiface.EventAPI {
+	return (*EventAPI)(api)
+}
+
 // WithOptions returns api with global options applied
 func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, error) {
 	settings := api.parentOpts // make sure to copy
@@ -208,6 +221,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 
 		chequeManger: n.ChequeManager,
 
+		mineService: n.MineService,
+
 		chain:  n.Chain,
 		wallet: n.Wallet,
 
@@ -0,0 +1,81 @@
+package coreapi
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/mineservice"
+)
+
+type EventAPI CoreAPI
+
+func (api *EventAPI) List(ctx context.Context, since uint64, types ...string) ([]mineiface.Event, error) {
+	bus, err := api.events(types)
+	if err != nil {
+		return nil, err
+	}
+	list := bus.Events(since, types...)
+	events := make([]mineiface.Event, 0, len(list))
+	for _, e := range list {
+		event, err := convertEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (api *EventAPI) Subscribe(ctx context.Context, since uint64, types ...string) (<-chan mineiface.Event, error) {
+	bus, err := api.events(types)
+	if err != nil {
+		return nil, err
+	}
+	in := bus.Subscribe(ctx, since, types...)
+	out := make(chan mineiface.Event)
+	go func() {
+		defer close(out)
+		for e := range in {
+			event, err := convertEvent(e)
+			if err != nil {
+				// the payloads are plain structs, this does not happen
+				continue
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (api *EventAPI) events(types []string) (*mineservice.EventBus, error) {
+	if err := api.checkOnline(false); err != nil {
+		return nil, err
+	}
+	if api.mineService == nil {
+		return nil, errMineServiceNotRunning
+	}
+	for _, t := range types {
+		if err := mineservice.ValidEventType(t); err != nil {
+			return nil, err
+		}
+	}
+	return api.mineService.Events(), nil
+}
+
+func convertEvent(e mineservice.Event) (mineiface.Event, error) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return mineiface.Event{}, err
+	}
+	return mineiface.Event{
+		Seq:  e.Seq,
+		Time: e.Time,
+		Type: e.Type,
+		Data: data,
+	}, nil
+}
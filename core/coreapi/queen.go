@@ -0,0 +1,68 @@
+package coreapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-ipfs/core/mine/mineiface"
+	"github.com/ipfs/go-ipfs/core/mine/mineservice"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var errMineServiceNotRunning = errors.New("mine service is not running")
+
+type QueenAPI CoreAPI
+
+var _ mineiface.MineAPI = (*CoreAPI)(nil)
+
+func (api *QueenAPI) List(ctx context.Context) ([]mineiface.Queen, error) {
+	if err := api.checkOnline(false); err != nil {
+		return nil, err
+	}
+	if api.mineService == nil {
+		return nil, errMineServiceNotRunning
+	}
+	lastReported := api.mineService.LastReportedQueen()
+	healths := api.mineService.QueenHealth()
+	queens := make([]mineiface.Queen, 0, len(healths))
+	for _, h := range healths {
+		q := mineiface.Queen{
+			ID:            h.ID,
+			Source:        h.Source,
+			Addrs:         h.Addrs,
+			Connectedness: api.peerHost.Network().Connectedness(h.ID).String(),
+			LastReported:  h.ID == lastReported,
+			LastPing:      h.LastPing,
+			LastPong:      h.LastPong,
+			RTT:           h.RTT,
+			Pings:         h.Pings,
+			Failures:      h.Failures,
+			SuccessRate:   h.SuccessRate,
+			BackoffUntil:  h.BackoffUntil,
+			LastError:     h.LastError,
+		}
+		for _, e := range h.RecentErrors {
+			q.RecentErrors = append(q.RecentErrors, mineiface.QueenError{Time: e.Time, Error: e.Error})
+		}
+		queens = append(queens, q)
+	}
+	return queens, nil
+}
+
+func (api *QueenAPI) Ping(ctx context.Context, p peer.ID) (mineiface.QueenPing, error) {
+	if err := api.checkOnline(false); err != nil {
+		return mineiface.QueenPing{}, err
+	}
+	if api.mineService == nil {
+		return mineiface.QueenPing{}, errMineServiceNotRunning
+	}
+	rtt, err := api.mineService.PingQueenByID(ctx, p)
+	if err == mineservice.ErrUnknownQueen {
+		return mineiface.QueenPing{}, err
+	}
+	ping := mineiface.QueenPing{ID: p, Success: err == nil, RTT: rtt}
+	if err != nil {
+		ping.Error = err.Error()
+	}
+	return ping, nil
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-ipfs/core/mine/contracts/ant_locker"
 	"github.com/ipfs/go-ipfs/core/mine/contracts/erc20"
 	"github.com/ipfs/go-ipfs/core/mine/crypto"
@@ -14,6 +14,8 @@ import (
 	"github.com/ipfs/go-ipfs/core/mine/transaction"
 	logging "github.com/ipfs/go-log"
 	"math/big"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,9 +26,21 @@ func init() {
 }
 
 const (
+	pendingLockPrefix = "/chain/pendinglock/"
+	chainIDKey        = "/chain/chainid"
+
 	maxDelay          = 1 * time.Minute
 	cancellationDepth = 6
 	blocktime         = time.Second * 3
+
+	connectTimeout      = 30 * time.Second
+	minReconnectBackoff = 5 * time.Second
+	maxReconnectBackoff = time.Minute
+	healthCheckInterval = 30 * time.Second
+	healthCheckTimeout  = 10 * time.Second
+	// maxHealthCheckFailures is the number of health checks in a row without
+	// a healthy endpoint after which the chain is unavailable.
+	maxHealthCheckFailures = 3
 )
 
 var (
@@ -34,54 +48,224 @@ var (
 	balanceCheckMaxRetries      = 10
 )
 
+// BlockChain is the chain behind the configured RPC endpoints. It connects in
+// the background and reconnects when no endpoint answers, the calls made
+// while it is not connected fail with ErrChainUnavailable.
 type BlockChain struct {
+	endpoints          *endpointPool
+	signer             crypto.Signer
+	stateStore         statestore.StateStore
 	lockerContract     common.Address
-	tokenContract      common.Address
-	ethClient          *ethclient.Client
+	ethClient          *lazyBackend
 	transactionMonitor transaction.Monitor
-	transactionService transaction.Service
+	transactionService *lazyService
+
+	lock          sync.RWMutex
+	chainID       *big.Int
+	tokenContract common.Address
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
-func NewChain(ctx context.Context,
-	ethAddress common.Address,
+// NewChain returns the chain behind the endpoints and starts connecting to
+// them. The endpoints must serve chainID, or when it is nil the chain saved
+// by the first connection of the node. The first connection without either
+// adopts the chain of the first endpoint answering.
+func NewChain(ethAddress common.Address,
 	stateStore statestore.StateStore,
 	signer crypto.Signer,
-	endpoint string,
-	lockerContract common.Address) (*BlockChain, error) {
-	backend, err := ethclient.Dial(endpoint)
-	if err != nil {
-		return nil, fmt.Errorf("dial eth client: %w", err)
+	endpoints []string,
+	chainID *big.Int,
+	lockerContract common.Address) *BlockChain {
+	if chainID == nil {
+		chainID = savedChainID(stateStore)
 	}
-	chainID, err := backend.ChainID(ctx)
-	if err != nil {
-		log.Infof("could not connect to backend at %v", endpoint)
-		return nil, fmt.Errorf("get chain id: %w", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := newLazyBackend()
+	c := &BlockChain{
+		endpoints:          newEndpointPool(endpoints, chainID),
+		signer:             signer,
+		stateStore:         stateStore,
+		lockerContract:     lockerContract,
+		ethClient:          backend,
+		transactionMonitor: transaction.NewMonitor(backend, ethAddress, blocktime, cancellationDepth),
+		transactionService: &lazyService{backend: backend},
+		ctx:                ctx,
+		cancel:             cancel,
 	}
-	transactionMonitor := transaction.NewMonitor(backend, ethAddress, blocktime, cancellationDepth)
-	transactionService, err := transaction.NewService(backend, signer, stateStore, chainID, transactionMonitor)
-	if err != nil {
-		return nil, fmt.Errorf("new transaction service: %w", err)
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// run connects to the endpoints, checks their health while connected and
+// reconnects with an exponential backoff.
+func (c *BlockChain) run() {
+	defer c.wg.Done()
+	backoff := minReconnectBackoff
+	for {
+		err := c.connect()
+		if err == nil {
+			backoff = minReconnectBackoff
+			err = c.watch()
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.disconnect(err)
+		log.Warnf("chain unavailable, checking the endpoints again in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
 	}
-	locker := ant_locker.NewLocker(backend, transactionService, lockerContract)
-	tokenContract, err := locker.TokenContractAddress(ctx)
-	if err != nil {
-		log.Errorf("failed to get token contract address: %v", err)
-		return nil, err
+}
+
+// connect checks the endpoints until one of them is healthy. The transaction
+// service is created on the first connection, once the chain id is known.
+func (c *BlockChain) connect() error {
+	ctx, cancel := context.WithTimeout(c.ctx, connectTimeout)
+	defer cancel()
+	if c.endpoints.check(ctx) == 0 {
+		return c.endpointsError()
 	}
+	chainID := c.endpoints.ChainID()
 
-	return &BlockChain{
-		ethClient:          backend,
-		lockerContract:     lockerContract,
-		tokenContract:      tokenContract,
-		transactionMonitor: transactionMonitor,
-		transactionService: transactionService,
-	}, nil
+	// run is the only writer of the connection state, it reads it unlocked
+	if c.chainID == nil {
+		service, err := transaction.NewService(c.ethClient, c.signer, c.stateStore, chainID, c.transactionMonitor)
+		if err != nil {
+			return fmt.Errorf("new transaction service: %w", err)
+		}
+		c.transactionService.set(service)
+		c.lock.Lock()
+		c.chainID = chainID
+		c.lock.Unlock()
+		if err := c.stateStore.Put(datastore.NewKey(chainIDKey), chainID.String()); err != nil {
+			log.Errorf("failed to save the chain id: %v", err)
+		}
+	}
+	c.ethClient.set(transaction.NewInstrumentedBackend(c.endpoints), nil)
+	if c.tokenContract == (common.Address{}) {
+		locker := ant_locker.NewLocker(c.ethClient, c.transactionService, c.lockerContract)
+		tokenContract, err := locker.TokenContractAddress(ctx)
+		if err != nil {
+			return fmt.Errorf("get token contract address: %w", err)
+		}
+		c.lock.Lock()
+		c.tokenContract = tokenContract
+		c.lock.Unlock()
+	}
+	log.Infof("connected to chain %v", chainID)
+	chainConnected.Set(1)
+	return nil
+}
+
+// savedChainID returns the chain id saved by the first connection, nil if
+// there is none.
+func savedChainID(stateStore statestore.StateStore) *big.Int {
+	var s string
+	if err := stateStore.Get(datastore.NewKey(chainIDKey), &s); err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorf("failed to load the chain id: %v", err)
+		}
+		return nil
+	}
+	chainID, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		log.Errorf("invalid saved chain id %q", s)
+		return nil
+	}
+	return chainID
+}
+
+// watch checks the endpoints until none of them is healthy
+// maxHealthCheckFailures times in a row.
+func (c *BlockChain) watch() error {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+		if c.endpoints.check(c.ctx) > 0 {
+			failures = 0
+			continue
+		}
+		failures++
+		err := c.endpointsError()
+		log.Debugf("chain health check failed %v times: %v", failures, err)
+		if failures >= maxHealthCheckFailures {
+			return err
+		}
+	}
+}
+
+// endpointsError returns the errors of the endpoints.
+func (c *BlockChain) endpointsError() error {
+	var errs []string
+	for _, status := range c.endpoints.Status() {
+		reason := status.LastError
+		if reason == "" {
+			reason = fmt.Sprintf("rate limited until %v", status.BackoffUntil.Format(time.RFC3339))
+		}
+		errs = append(errs, fmt.Sprintf("%v: %v", status.URL, reason))
+	}
+	if len(errs) == 0 {
+		return errNoEndpoint
+	}
+	return fmt.Errorf("%w (%v)", errNoHealthyEndpoint, strings.Join(errs, "; "))
+}
+
+func (c *BlockChain) disconnect(reason error) {
+	c.ethClient.set(nil, reason)
+	chainConnected.Set(0)
+}
+
+// Close stops the reconnections and the transaction service.
+func (c *BlockChain) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	c.transactionService.Close()
+	c.transactionMonitor.Close()
+	c.disconnect(errors.New("chain closed"))
+	c.endpoints.Close()
+	return nil
 }
 
 func (c *BlockChain) Backend() transaction.Backend {
 	return c.ethClient
 }
 
+// ChainID returns the id of the chain, which is known once connected.
+func (c *BlockChain) ChainID() (*big.Int, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.chainID == nil {
+		_, err := c.ethClient.get()
+		if err == nil {
+			err = unavailable(nil)
+		}
+		return nil, err
+	}
+	return c.chainID, nil
+}
+
+// Endpoints returns the health of the RPC endpoints.
+func (c *BlockChain) Endpoints() []EndpointStatus {
+	return c.endpoints.Status()
+}
+
 func (c *BlockChain) TransactionMonitor() transaction.Monitor {
 	return c.transactionMonitor
 }
@@ -90,73 +274,164 @@ func (c *BlockChain) TransactionService() transaction.Service {
 	return c.transactionService
 }
 
+// LockToken locks the minimum amount of tokens for the node, approving the
+// locker contract to spend them first. The hashes of the approve and lock
+// transactions are saved as they are sent, a later call waits for them
+// instead of sending them again.
 func (c *BlockChain) LockToken(
 	ctx context.Context,
 	nodeId string,
 	ethAddress common.Address,
 ) error {
 	log.Infof("eth address: %v", ethAddress)
+	if _, err := c.ethClient.get(); err != nil {
+		return err
+	}
 
 	locker := ant_locker.NewLocker(c.ethClient, c.transactionService, c.lockerContract)
 
 	lockerInfo, err := locker.GetLockInfo(ctx, nodeId)
 	if err == nil && lockerInfo.LockedAmount.Cmp(big.NewInt(0)) > 0 {
 		log.Infof("lock token: %v", lockerInfo.LockedAmount.String())
+		c.clearPendingLock(nodeId)
 		return nil
 	}
 	if err != nil {
 		log.Errorf("tail to get GetLockInfo: %v", err)
 	}
 
-	if err := checkEthBalance(ctx, c.ethClient, ethAddress); err != nil {
-		return err
-	}
-
-	tokenContract, err := locker.TokenContractAddress(ctx)
-	if err != nil {
-		log.Errorf("failed to get token contract address: %v", err)
-		return err
+	pending := c.pendingLock(nodeId)
+	if pending.Lock != (common.Hash{}) {
+		log.Infof("waiting for the lock transaction %v", pending.Lock.Hex())
+		ok, err := c.waitPending(ctx, pending.Lock)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.clearPendingLock(nodeId)
+			return nil
+		}
+		pending = &PendingLock{}
+		c.savePendingLock(nodeId, pending)
 	}
-	lockAmount, err := locker.GetMinLockAmount(ctx)
-	if err != nil {
-		log.Errorf("failed to get token contract address: %v", err)
-		return err
+	if pending.Approve != (common.Hash{}) {
+		log.Infof("waiting for the approve transaction %v", pending.Approve.Hex())
+		ok, err := c.waitPending(ctx, pending.Approve)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			pending.Approve = common.Hash{}
+			c.savePendingLock(nodeId, pending)
+		}
 	}
-	erc20Token := erc20.New(c.ethClient, c.transactionService, tokenContract)
-	if err := checkTokenBalance(ctx, erc20Token, ethAddress, lockAmount); err != nil {
-		return err
+
+	if pending.Approve == (common.Hash{}) {
+		if err := checkEthBalance(ctx, c.ethClient, ethAddress); err != nil {
+			return err
+		}
+
+		tokenContract, err := locker.TokenContractAddress(ctx)
+		if err != nil {
+			log.Errorf("failed to get token contract address: %v", err)
+			return err
+		}
+		lockAmount, err := locker.GetMinLockAmount(ctx)
+		if err != nil {
+			log.Errorf("failed to get token contract address: %v", err)
+			return err
+		}
+		erc20Token := erc20.New(c.ethClient, c.transactionService, tokenContract)
+		if err := checkTokenBalance(ctx, erc20Token, ethAddress, lockAmount); err != nil {
+			return err
+		}
+		tx, err := erc20Token.Approve(ctx, c.lockerContract, lockAmount)
+		if err != nil {
+			log.Errorf("failed to approve: %v", err)
+			return err
+		}
+		pending.Approve = tx
+		c.savePendingLock(nodeId, pending)
+		receipt, err := c.transactionService.WaitForReceipt(ctx, tx)
+		if err != nil {
+			log.Errorf("failed to approve: %v", err)
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			log.Errorf("failed to approve: %v", receipt.Status)
+			c.clearPendingLock(nodeId)
+			return errors.New(fmt.Sprintf("receipt=%v", receipt))
+		}
 	}
-	tx, err := erc20Token.Approve(ctx, c.lockerContract, lockAmount)
+
+	tx, err := locker.Lock(ctx, nodeId, ethAddress)
 	if err != nil {
-		log.Errorf("failed to approve: %v", err)
+		log.Errorf("failed to log: %v", err)
 		return err
 	}
+	pending.Lock = tx
+	c.savePendingLock(nodeId, pending)
+
 	receipt, err := c.transactionService.WaitForReceipt(ctx, tx)
 	if err != nil {
-		log.Errorf("failed to approve: %v", err)
+		log.Errorf("failed to token token: %v", err)
 		return err
 	}
+	c.clearPendingLock(nodeId)
 	if receipt.Status != types.ReceiptStatusSuccessful {
-		log.Errorf("failed to approve: %v", receipt.Status)
+		log.Errorf("failed to lock token: %v", receipt.Status)
 		return errors.New(fmt.Sprintf("receipt=%v", receipt))
 	}
+	return nil
+}
 
-	tx, err = locker.Lock(ctx, nodeId, ethAddress)
-	if err != nil {
-		log.Errorf("failed to log: %v", err)
-		return err
+// PendingLock is the approve and lock transactions sent by LockToken.
+type PendingLock struct {
+	Approve common.Hash
+	Lock    common.Hash
+}
+
+func PendingLockKey(nodeId string) datastore.Key {
+	return datastore.NewKey(pendingLockPrefix + nodeId)
+}
+
+func (c *BlockChain) pendingLock(nodeId string) *PendingLock {
+	pending := &PendingLock{}
+	if err := c.stateStore.Get(PendingLockKey(nodeId), pending); err != nil && err != datastore.ErrNotFound {
+		log.Errorf("failed to load the pending lock transactions: %v", err)
+	}
+	return pending
+}
+
+func (c *BlockChain) savePendingLock(nodeId string, pending *PendingLock) {
+	if err := c.stateStore.Put(PendingLockKey(nodeId), pending); err != nil {
+		log.Errorf("failed to save the pending lock transactions: %v", err)
+	}
+}
+
+func (c *BlockChain) clearPendingLock(nodeId string) {
+	if err := c.stateStore.Delete(PendingLockKey(nodeId)); err != nil && err != datastore.ErrNotFound {
+		log.Errorf("failed to remove the pending lock transactions: %v", err)
 	}
+}
 
-	receipt, err = c.transactionService.WaitForReceipt(ctx, tx)
+// waitPending waits for a transaction sent by an earlier call. It returns
+// false if the transaction failed, was cancelled or is unknown, it must then
+// be sent again.
+func (c *BlockChain) waitPending(ctx context.Context, txHash common.Hash) (bool, error) {
+	receipt, err := c.transactionService.WaitForReceipt(ctx, txHash)
+	if errors.Is(err, transaction.ErrTransactionCancelled) || errors.Is(err, transaction.ErrUnknownTransaction) {
+		log.Warnf("transaction %v was not mined: %v", txHash.Hex(), err)
+		return false, nil
+	}
 	if err != nil {
-		log.Errorf("failed to token token: %v", err)
-		return err
+		return false, err
 	}
 	if receipt.Status != types.ReceiptStatusSuccessful {
-		log.Errorf("failed to lock token: %v", receipt.Status)
-		return errors.New(fmt.Sprintf("receipt=%v", receipt))
+		log.Warnf("transaction %v failed: %v", txHash.Hex(), receipt.Status)
+		return false, nil
 	}
-	return nil
+	return true, nil
 }
 
 func (c *BlockChain) BNBBalanceOf(ctx context.Context, account common.Address) (*big.Int, error) {
@@ -164,6 +439,27 @@ func (c *BlockChain) BNBBalanceOf(ctx context.Context, account common.Address) (
 }
 
 func (c *BlockChain) AntzBalanceOf(ctx context.Context, account common.Address) (*big.Int, error) {
-	erc20Token := erc20.New(c.ethClient, c.transactionService, c.tokenContract)
+	c.lock.RLock()
+	tokenContract := c.tokenContract
+	c.lock.RUnlock()
+	if tokenContract == (common.Address{}) {
+		_, err := c.ethClient.get()
+		if err == nil {
+			err = unavailable(nil)
+		}
+		return nil, err
+	}
+	erc20Token := erc20.New(c.ethClient, c.transactionService, tokenContract)
 	return erc20Token.BalanceOf(ctx, account)
 }
+
+// LockedAmount returns the amount of tokens locked for the node, zero if the
+// node has no pledge.
+func (c *BlockChain) LockedAmount(ctx context.Context, nodeId string) (*big.Int, error) {
+	locker := ant_locker.NewLocker(c.ethClient, c.transactionService, c.lockerContract)
+	info, err := locker.GetLockInfo(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+	return info.LockedAmount, nil
+}
@@ -0,0 +1,495 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBlocksBehind is how far the head of an endpoint may lag behind the
+	// best head before it is considered unhealthy.
+	maxBlocksBehind = 10
+	// rateLimitBackoff is how long an endpoint which rate limited a request
+	// is left alone.
+	rateLimitBackoff = 30 * time.Second
+
+	// rpcLimitExceeded is the JSON-RPC error code some providers answer with
+	// instead of HTTP 429.
+	rpcLimitExceeded = -32005
+)
+
+var (
+	errNoEndpoint        = errors.New("no endpoint configured")
+	errNoHealthyEndpoint = errors.New("no healthy endpoint")
+)
+
+// EndpointStatus is the health of a chain RPC endpoint.
+type EndpointStatus struct {
+	URL          string // without the path and query, which often hold an API key
+	Healthy      bool
+	Submitter    bool // whether the transactions are submitted through it
+	ChainID      string
+	BlockNumber  uint64
+	HeadTime     time.Time
+	Latency      time.Duration
+	LastCheck    time.Time
+	Failures     int
+	LastError    string
+	BackoffUntil time.Time
+}
+
+type endpoint struct {
+	url    string
+	name   string
+	client *ethclient.Client
+
+	healthy      bool
+	chainID      *big.Int
+	blockNumber  uint64
+	headTime     time.Time
+	latency      time.Duration
+	lastCheck    time.Time
+	failures     int
+	lastError    string
+	backoffUntil time.Time
+}
+
+func (e *endpoint) usable(now time.Time) bool {
+	return e.healthy && e.client != nil && !now.Before(e.backoffUntil)
+}
+
+// endpointCheck is the outcome of a health check of an endpoint.
+type endpointCheck struct {
+	chainID *big.Int
+	header  *types.Header
+	latency time.Duration
+	err     error
+}
+
+// endpointPool is a transaction.Backend over several RPC endpoints of the
+// same chain. The reads go to the healthiest endpoint and fail over to the
+// next one when an endpoint errors or rate limits. The calls which depend on
+// the pending state of an endpoint, the pending nonce and the submission of
+// transactions, stick to one endpoint as long as it stays healthy.
+type endpointPool struct {
+	lock      sync.RWMutex
+	endpoints []*endpoint
+	chainID   *big.Int // the expected chain, or the one of the first endpoint which answered
+	submitter *endpoint
+}
+
+// newEndpointPool returns a pool of the endpoints serving the chain. A nil
+// chainID adopts the chain of the first endpoint, in the configured order,
+// which answers a health check.
+func newEndpointPool(urls []string, chainID *big.Int) *endpointPool {
+	p := &endpointPool{chainID: chainID}
+	for _, u := range urls {
+		p.endpoints = append(p.endpoints, &endpoint{url: u, name: endpointName(u)})
+	}
+	return p
+}
+
+// endpointName returns the url without the credentials, path and query.
+func endpointName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "invalid endpoint"
+	}
+	name := u.Scheme + "://" + u.Host
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		name += "/..."
+	}
+	return name
+}
+
+// check checks the health of every endpoint and returns the number of
+// usable ones. An endpoint is healthy when it serves the chain of the pool
+// and its head is recent and not far behind the best head.
+func (p *endpointPool) check(ctx context.Context) int {
+	results := make([]endpointCheck, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			results[i] = p.probe(cctx, e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.chainID == nil {
+		for _, r := range results {
+			if r.err == nil {
+				p.chainID = r.chainID
+				break
+			}
+		}
+	}
+	var best uint64
+	for _, r := range results {
+		if r.err == nil && r.chainID.Cmp(p.chainID) == 0 && r.header.Number.Uint64() > best {
+			best = r.header.Number.Uint64()
+		}
+	}
+
+	now := time.Now()
+	usable := 0
+	for i, e := range p.endpoints {
+		r := results[i]
+		e.lastCheck = now
+		err := r.err
+		if err == nil {
+			number := r.header.Number.Uint64()
+			headTime := time.Unix(int64(r.header.Time), 0)
+			e.chainID, e.blockNumber, e.headTime, e.latency = r.chainID, number, headTime, r.latency
+			switch {
+			case r.chainID.Cmp(p.chainID) != 0:
+				err = fmt.Errorf("endpoint is on chain %v instead of %v", r.chainID, p.chainID)
+			case now.Sub(headTime) > maxDelay:
+				err = fmt.Errorf("head %v is %v old", number, now.Sub(headTime).Round(time.Second))
+			case best-number > maxBlocksBehind:
+				err = fmt.Errorf("head %v is %v blocks behind", number, best-number)
+			}
+		}
+		if err != nil {
+			p.markFailed(e, err)
+			continue
+		}
+		e.healthy = true
+		e.failures = 0
+		e.lastError = ""
+		endpointHealthy.WithLabelValues(e.name).Set(1)
+		if e.usable(now) {
+			usable++
+		}
+	}
+	return usable
+}
+
+// probe dials the endpoint if needed and reads its chain id and head.
+func (p *endpointPool) probe(ctx context.Context, e *endpoint) endpointCheck {
+	p.lock.RLock()
+	client := e.client
+	p.lock.RUnlock()
+	if client == nil {
+		var err error
+		client, err = ethclient.DialContext(ctx, e.url)
+		if err != nil {
+			return endpointCheck{err: fmt.Errorf("dial eth client: %w", err)}
+		}
+		p.lock.Lock()
+		e.client = client
+		p.lock.Unlock()
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return endpointCheck{err: fmt.Errorf("get chain id: %w", err)}
+	}
+	start := time.Now()
+	header, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return endpointCheck{err: fmt.Errorf("get head: %w", err)}
+	}
+	return endpointCheck{chainID: chainID, header: header, latency: time.Since(start)}
+}
+
+// markFailed marks the endpoint unhealthy until its next successful health
+// check, the url is left out of the error as it may hold an API key. The
+// client of an endpoint which failed for another reason than a rate limit is
+// closed, it is dialed again by the next health check.
+func (p *endpointPool) markFailed(e *endpoint, err error) {
+	e.healthy = false
+	e.failures++
+	e.lastError = strings.ReplaceAll(err.Error(), e.url, e.name)
+	if isRateLimited(err) {
+		e.backoffUntil = time.Now().Add(rateLimitBackoff)
+	} else if e.client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+	if p.submitter == e {
+		p.submitter = nil
+	}
+	endpointHealthy.WithLabelValues(e.name).Set(0)
+}
+
+// pick returns the usable endpoint with the lowest latency, or the sticky
+// submitter if it is still usable.
+func (p *endpointPool) pick(sticky bool) (*endpoint, *ethclient.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	if sticky && p.submitter != nil && p.submitter.usable(now) {
+		return p.submitter, p.submitter.client
+	}
+	var candidates []*endpoint
+	for _, e := range p.endpoints {
+		if e.usable(now) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].latency < candidates[j].latency
+	})
+	e := candidates[0]
+	if sticky {
+		log.Infof("submitting transactions through %v", e.name)
+		p.submitter = e
+	}
+	return e, e.client
+}
+
+// call calls fn with the endpoints in turn until one answers. It fails with
+// ErrChainUnavailable when every endpoint failed.
+func (p *endpointPool) call(ctx context.Context, sticky bool, fn func(client *ethclient.Client) error) error {
+	lastErr := errNoHealthyEndpoint
+	if len(p.endpoints) == 0 {
+		lastErr = errNoEndpoint
+	}
+	for range p.endpoints {
+		e, client := p.pick(sticky)
+		if e == nil {
+			break
+		}
+		err := fn(client)
+		if err == nil || !shouldFailover(ctx, err) {
+			return err
+		}
+		log.Debugf("chain endpoint %v failed, failing over: %v", e.name, err)
+		endpointFailovers.WithLabelValues(e.name).Inc()
+		p.lock.Lock()
+		p.markFailed(e, err)
+		p.lock.Unlock()
+		lastErr = err
+	}
+	return unavailable(lastErr)
+}
+
+// shouldFailover reports whether err is a failure of the endpoint rather
+// than of the request, so that another endpoint may answer. An endpoint
+// answering with an HTTP error status failed, a JSON-RPC error is the answer
+// to the request unless it is a rate limit.
+func shouldFailover(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == rpcLimitExceeded
+	}
+	return true
+}
+
+func isRateLimited(err error) bool {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == rpcLimitExceeded
+}
+
+// ChainID returns the chain of the pool, nil until an endpoint answered.
+func (p *endpointPool) ChainID() *big.Int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.chainID
+}
+
+// Status returns the health of the endpoints in the configured order.
+func (p *endpointPool) Status() []EndpointStatus {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	list := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		status := EndpointStatus{
+			URL:          e.name,
+			Healthy:      e.healthy,
+			Submitter:    e == p.submitter,
+			BlockNumber:  e.blockNumber,
+			HeadTime:     e.headTime,
+			Latency:      e.latency,
+			LastCheck:    e.lastCheck,
+			Failures:     e.failures,
+			LastError:    e.lastError,
+			BackoffUntil: e.backoffUntil,
+		}
+		if e.chainID != nil {
+			status.ChainID = e.chainID.String()
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+// Close closes the clients of the endpoints.
+func (p *endpointPool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, e := range p.endpoints {
+		if e.client != nil {
+			e.client.Close()
+			e.client = nil
+		}
+		e.healthy = false
+	}
+	p.submitter = nil
+}
+
+func (p *endpointPool) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		code, err = client.CodeAt(ctx, contract, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (p *endpointPool) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) (result []byte, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		result, err = client.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (p *endpointPool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *endpointPool) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = p.call(ctx, true, func(client *ethclient.Client) error {
+		code, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+func (p *endpointPool) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = p.call(ctx, true, func(client *ethclient.Client) error {
+		nonce, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (p *endpointPool) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		price, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+func (p *endpointPool) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		tip, err = client.SuggestGasTipCap(ctx)
+		return err
+	})
+	return tip, err
+}
+
+func (p *endpointPool) EstimateGas(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		gas, err = client.EstimateGas(ctx, call)
+		return err
+	})
+	return gas, err
+}
+
+func (p *endpointPool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return p.call(ctx, true, func(client *ethclient.Client) error {
+		return client.SendTransaction(ctx, tx)
+	})
+}
+
+func (p *endpointPool) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		logs, err = client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+func (p *endpointPool) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		sub, err = client.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return sub, err
+}
+
+func (p *endpointPool) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+func (p *endpointPool) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		tx, isPending, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (p *endpointPool) BlockNumber(ctx context.Context) (number uint64, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		number, err = client.BlockNumber(ctx)
+		return err
+	})
+	return number, err
+}
+
+func (p *endpointPool) BlockByNumber(ctx context.Context, number *big.Int) (block *types.Block, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		block, err = client.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+func (p *endpointPool) BalanceAt(ctx context.Context, address common.Address, block *big.Int) (balance *big.Int, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		balance, err = client.BalanceAt(ctx, address, block)
+		return err
+	})
+	return balance, err
+}
+
+func (p *endpointPool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (nonce uint64, err error) {
+	err = p.call(ctx, false, func(client *ethclient.Client) error {
+		nonce, err = client.NonceAt(ctx, account, blockNumber)
+		return err
+	})
+	return nonce, err
+}
@@ -4,12 +4,22 @@ import (
 	"context"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ipfs/go-ipfs/core/mine/transaction"
+	"io"
 	"math/big"
 )
 
+// Chain is the chain behind the RPC endpoints. Its calls fail with
+// ErrChainUnavailable while no endpoint can be reached.
 type Chain interface {
+	io.Closer
+
 	Backend() transaction.Backend
 
+	// Endpoints returns the health of the RPC endpoints
+	Endpoints() []EndpointStatus
+
+	ChainID() (*big.Int, error)
+
 	TransactionMonitor() transaction.Monitor
 
 	TransactionService() transaction.Service
@@ -19,4 +29,6 @@ type Chain interface {
 	BNBBalanceOf(ctx context.Context, account common.Address) (*big.Int, error)
 
 	AntzBalanceOf(ctx context.Context, account common.Address) (*big.Int, error)
+
+	LockedAmount(ctx context.Context, nodeId string) (*big.Int, error)
 }
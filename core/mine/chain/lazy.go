@@ -0,0 +1,322 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+	"math/big"
+	"sync"
+)
+
+var (
+	ErrChainUnavailable = errors.New("chain unavailable")
+)
+
+// unavailable returns ErrChainUnavailable with the reason the chain is not
+// connected.
+func unavailable(reason error) error {
+	if reason == nil {
+		return fmt.Errorf("%w: not connected yet", ErrChainUnavailable)
+	}
+	return fmt.Errorf("%w: %v", ErrChainUnavailable, reason)
+}
+
+// lazyBackend is the backend of the chain while it connects and reconnects.
+// It forwards the calls to the connected backend and fails them with
+// ErrChainUnavailable while there is none.
+type lazyBackend struct {
+	lock    sync.RWMutex
+	backend transaction.Backend
+	reason  error
+	ready   chan struct{} // closed once connected
+}
+
+func newLazyBackend() *lazyBackend {
+	return &lazyBackend{ready: make(chan struct{})}
+}
+
+// set sets the connected backend, or the reason the chain is unavailable if
+// backend is nil.
+func (b *lazyBackend) set(backend transaction.Backend, reason error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if backend != nil && b.backend == nil {
+		close(b.ready)
+	}
+	if backend == nil && b.backend != nil {
+		b.ready = make(chan struct{})
+	}
+	b.backend = backend
+	b.reason = reason
+}
+
+func (b *lazyBackend) get() (transaction.Backend, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.backend == nil {
+		return nil, unavailable(b.reason)
+	}
+	return b.backend, nil
+}
+
+// wait waits until the chain is connected or ctx is done.
+func (b *lazyBackend) wait(ctx context.Context) error {
+	for {
+		b.lock.RLock()
+		connected, ready := b.backend != nil, b.ready
+		b.lock.RUnlock()
+		if connected {
+			return nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *lazyBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.CodeAt(ctx, contract, blockNumber)
+}
+
+func (b *lazyBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.CallContract(ctx, call, blockNumber)
+}
+
+func (b *lazyBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.HeaderByNumber(ctx, number)
+}
+
+func (b *lazyBackend) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.PendingCodeAt(ctx, account)
+}
+
+func (b *lazyBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	backend, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	return backend.PendingNonceAt(ctx, account)
+}
+
+func (b *lazyBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.SuggestGasPrice(ctx)
+}
+
+func (b *lazyBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.SuggestGasTipCap(ctx)
+}
+
+func (b *lazyBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	backend, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	return backend.EstimateGas(ctx, call)
+}
+
+func (b *lazyBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	backend, err := b.get()
+	if err != nil {
+		return err
+	}
+	return backend.SendTransaction(ctx, tx)
+}
+
+func (b *lazyBackend) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.FilterLogs(ctx, query)
+}
+
+func (b *lazyBackend) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.SubscribeFilterLogs(ctx, query, ch)
+}
+
+func (b *lazyBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.TransactionReceipt(ctx, txHash)
+}
+
+func (b *lazyBackend) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, false, err
+	}
+	return backend.TransactionByHash(ctx, hash)
+}
+
+func (b *lazyBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	backend, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	return backend.BlockNumber(ctx)
+}
+
+func (b *lazyBackend) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.BlockByNumber(ctx, number)
+}
+
+func (b *lazyBackend) BalanceAt(ctx context.Context, address common.Address, block *big.Int) (*big.Int, error) {
+	backend, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+	return backend.BalanceAt(ctx, address, block)
+}
+
+func (b *lazyBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	backend, err := b.get()
+	if err != nil {
+		return 0, err
+	}
+	return backend.NonceAt(ctx, account, blockNumber)
+}
+
+// lazyService is the transaction service of the chain, which can only be
+// created once the chain id is known. Until then its calls fail with
+// ErrChainUnavailable, except WaitForReceipt which waits for the chain.
+type lazyService struct {
+	backend *lazyBackend
+
+	lock    sync.RWMutex
+	service transaction.Service
+}
+
+func (s *lazyService) set(service transaction.Service) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.service = service
+}
+
+func (s *lazyService) get() (transaction.Service, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.service == nil {
+		_, err := s.backend.get()
+		if err == nil {
+			err = unavailable(nil)
+		}
+		return nil, err
+	}
+	return s.service, nil
+}
+
+func (s *lazyService) Close() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.service == nil {
+		return nil
+	}
+	return s.service.Close()
+}
+
+func (s *lazyService) Send(ctx context.Context, request *transaction.TxRequest) (common.Hash, error) {
+	service, err := s.get()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return service.Send(ctx, request)
+}
+
+func (s *lazyService) Call(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+	service, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return service.Call(ctx, request)
+}
+
+func (s *lazyService) WaitForReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if err := s.backend.wait(ctx); err != nil {
+		return nil, err
+	}
+	service, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return service.WaitForReceipt(ctx, txHash)
+}
+
+func (s *lazyService) WatchSentTransaction(txHash common.Hash) (<-chan types.Receipt, <-chan error, error) {
+	service, err := s.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	return service.WatchSentTransaction(txHash)
+}
+
+func (s *lazyService) StoredTransaction(txHash common.Hash) (*transaction.StoredTransaction, error) {
+	service, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return service.StoredTransaction(txHash)
+}
+
+func (s *lazyService) PendingTransactions() ([]common.Hash, error) {
+	service, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return service.PendingTransactions()
+}
+
+func (s *lazyService) ResendTransaction(ctx context.Context, txHash common.Hash) error {
+	service, err := s.get()
+	if err != nil {
+		return err
+	}
+	return service.ResendTransaction(ctx, txHash)
+}
+
+func (s *lazyService) CancelTransaction(ctx context.Context, originalTxHash common.Hash) (common.Hash, error) {
+	service, err := s.get()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return service.CancelTransaction(ctx, originalTxHash)
+}
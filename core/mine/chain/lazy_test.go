@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+)
+
+type testBackend struct {
+	transaction.Backend
+}
+
+type testService struct {
+	transaction.Service
+}
+
+func TestLazyBackend(t *testing.T) {
+	backend := &testBackend{}
+	dialErr := errors.New("dial failed")
+
+	for _, tc := range []struct {
+		name    string
+		sets    []transaction.Backend // nil sets dialErr as the reason
+		reason  string                // expected in the error, empty if connected
+		waiting bool                  // whether wait blocks
+	}{
+		{"not connected yet", nil, "not connected yet", true},
+		{"failed to connect", []transaction.Backend{nil}, dialErr.Error(), true},
+		{"connected", []transaction.Backend{nil, backend}, "", false},
+		{"connected twice", []transaction.Backend{backend, backend}, "", false},
+		{"disconnected", []transaction.Backend{backend, nil}, dialErr.Error(), true},
+		{"reconnected", []transaction.Backend{backend, nil, backend}, "", false},
+	} {
+		b := newLazyBackend()
+		for _, set := range tc.sets {
+			if set == nil {
+				b.set(nil, dialErr)
+			} else {
+				b.set(set, nil)
+			}
+		}
+
+		got, err := b.get()
+		if tc.reason == "" {
+			if err != nil || got != backend {
+				t.Fatalf("%s: got %v, %v", tc.name, got, err)
+			}
+		} else if !errors.Is(err, ErrChainUnavailable) || !strings.Contains(err.Error(), tc.reason) {
+			t.Fatalf("%s: got %v, expected %v with %q", tc.name, err, ErrChainUnavailable, tc.reason)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err = b.wait(ctx)
+		cancel()
+		if waiting := err != nil; waiting != tc.waiting {
+			t.Fatalf("%s: wait returned %v", tc.name, err)
+		}
+	}
+}
+
+func TestLazyBackendWaitReconnect(t *testing.T) {
+	b := newLazyBackend()
+	b.set(&testBackend{}, nil)
+	b.set(nil, errors.New("connection lost"))
+
+	done := make(chan error)
+	go func() {
+		done <- b.wait(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned %v while disconnected", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.set(&testBackend{}, nil)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return once reconnected")
+	}
+}
+
+func TestLazyService(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		connected bool
+		service   bool
+		reason    string // expected in the error, empty if available
+	}{
+		{"not connected", false, false, "not connected yet"},
+		{"connected without chain id", true, false, "not connected yet"},
+		{"available", true, true, ""},
+	} {
+		s := &lazyService{backend: newLazyBackend()}
+		if tc.connected {
+			s.backend.set(&testBackend{}, nil)
+		}
+		service := &testService{}
+		if tc.service {
+			s.set(service)
+		}
+		got, err := s.get()
+		if tc.reason == "" {
+			if err != nil || got != service {
+				t.Fatalf("%s: got %v, %v", tc.name, got, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrChainUnavailable) || !strings.Contains(err.Error(), tc.reason) {
+			t.Fatalf("%s: got %v, expected %v with %q", tc.name, err, ErrChainUnavailable, tc.reason)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("%s: close failed: %v", tc.name, err)
+		}
+	}
+}
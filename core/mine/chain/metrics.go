@@ -0,0 +1,212 @@
+package chain
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+	minetypes "github.com/ipfs/go-ipfs/core/mine/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultScrapeCacheTTL is how long the chain metrics are served from the
+	// cache before the chain is queried again.
+	DefaultScrapeCacheTTL = 30 * time.Second
+
+	scrapeTimeout = 10 * time.Second
+)
+
+var (
+	chainConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "chain",
+		Name:      "connected",
+		Help:      "Whether a chain RPC endpoint is connected, 1 if connected.",
+	})
+
+	endpointHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "chain",
+		Name:      "endpoint_healthy",
+		Help:      "Whether the chain RPC endpoint passed its last health check, 1 if healthy.",
+	}, []string{"endpoint"})
+
+	endpointFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "chain",
+		Name:      "endpoint_failovers_total",
+		Help:      "Number of chain RPC requests which failed over from the endpoint to another one.",
+	}, []string{"endpoint"})
+)
+
+var (
+	bnbBalanceDesc = prometheus.NewDesc("ant_chain_bnb_balance",
+		"BNB balance of the wallet address.", []string{"address"}, nil)
+	antzBalanceDesc = prometheus.NewDesc("ant_chain_antz_balance",
+		"ANTZ balance of the wallet address.", []string{"address"}, nil)
+	nonceDesc = prometheus.NewDesc("ant_chain_nonce",
+		"Nonce of the wallet address, in the latest block or including the pending transactions.",
+		[]string{"address", "state"}, nil)
+	pendingTransactionsDesc = prometheus.NewDesc("ant_chain_pending_transactions",
+		"Number of transactions sent and not confirmed yet.", nil, nil)
+	oldestPendingTransactionAgeDesc = prometheus.NewDesc("ant_chain_oldest_pending_transaction_age_seconds",
+		"Age of the oldest transaction not confirmed yet.", nil, nil)
+	blockNumberDesc = prometheus.NewDesc("ant_chain_block_number",
+		"Number of the last block seen on the chain.", nil, nil)
+	headLagDesc = prometheus.NewDesc("ant_chain_head_lag_seconds",
+		"Time since the last block seen on the chain.", nil, nil)
+	syncedDesc = prometheus.NewDesc("ant_chain_synced",
+		"Whether the last block seen on the chain is recent enough, 1 if synced.", nil, nil)
+	scrapeErrorsDesc = prometheus.NewDesc("ant_chain_scrape_errors",
+		"Number of chain queries which failed during the last scrape.", nil, nil)
+)
+
+// Collector collects the wallet balances, the pending transactions and the
+// state of the chain. Every value needs a query to the chain, so they are
+// cached for ttl and a scrape within that time is served from the cache.
+type Collector struct {
+	chain     Chain
+	addresses func() ([]common.Address, error)
+	ttl       time.Duration
+
+	lock      sync.Mutex
+	metrics   []prometheus.Metric
+	scrapedAt time.Time
+}
+
+// NewCollector returns a collector of the chain metrics of the addresses.
+func NewCollector(chain Chain, addresses func() ([]common.Address, error), ttl time.Duration) *Collector {
+	if ttl <= 0 {
+		ttl = DefaultScrapeCacheTTL
+	}
+	return &Collector{
+		chain:     chain,
+		addresses: addresses,
+		ttl:       ttl,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bnbBalanceDesc
+	ch <- antzBalanceDesc
+	ch <- nonceDesc
+	ch <- pendingTransactionsDesc
+	ch <- oldestPendingTransactionAgeDesc
+	ch <- blockNumberDesc
+	ch <- headLagDesc
+	ch <- syncedDesc
+	ch <- scrapeErrorsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.metrics == nil || time.Since(c.scrapedAt) >= c.ttl {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		c.metrics = c.scrape(ctx)
+		c.scrapedAt = time.Now()
+		cancel()
+	}
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+// scrape queries the chain, a value which cannot be read is left out and
+// counted in the scrape errors.
+func (c *Collector) scrape(ctx context.Context) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	add := func(desc *prometheus.Desc, value float64, labels ...string) {
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...))
+	}
+	errs := 0
+
+	addresses, err := c.addresses()
+	if err != nil {
+		log.Errorf("failed to list wallet addresses: %v", err)
+		errs++
+	}
+	backend := c.chain.Backend()
+	for _, address := range addresses {
+		label := address.Hex()
+		if balance, err := c.chain.BNBBalanceOf(ctx, address); err == nil {
+			add(bnbBalanceDesc, toFloat(balance, minetypes.NBNDecimal), label)
+		} else {
+			log.Debugf("failed to get BNB balance of %v: %v", label, err)
+			errs++
+		}
+		if balance, err := c.chain.AntzBalanceOf(ctx, address); err == nil {
+			add(antzBalanceDesc, toFloat(balance, minetypes.ANTZDecimal), label)
+		} else {
+			log.Debugf("failed to get ANTZ balance of %v: %v", label, err)
+			errs++
+		}
+		if nonce, err := backend.NonceAt(ctx, address, nil); err == nil {
+			add(nonceDesc, float64(nonce), label, "latest")
+		} else {
+			log.Debugf("failed to get nonce of %v: %v", label, err)
+			errs++
+		}
+		if nonce, err := backend.PendingNonceAt(ctx, address); err == nil {
+			add(nonceDesc, float64(nonce), label, "pending")
+		} else {
+			log.Debugf("failed to get pending nonce of %v: %v", label, err)
+			errs++
+		}
+	}
+
+	service := c.chain.TransactionService()
+	if pending, err := service.PendingTransactions(); err == nil {
+		add(pendingTransactionsDesc, float64(len(pending)))
+		var oldest int64
+		for _, txHash := range pending {
+			stored, err := service.StoredTransaction(txHash)
+			if err != nil {
+				continue
+			}
+			if oldest == 0 || stored.Created < oldest {
+				oldest = stored.Created
+			}
+		}
+		age := 0.0
+		if oldest > 0 {
+			age = time.Since(time.Unix(oldest, 0)).Seconds()
+		}
+		add(oldestPendingTransactionAgeDesc, age)
+	} else {
+		log.Debugf("failed to list pending transactions: %v", err)
+		errs++
+	}
+
+	if number, err := backend.BlockNumber(ctx); err == nil {
+		add(blockNumberDesc, float64(number))
+	} else {
+		log.Debugf("failed to get block number: %v", err)
+		errs++
+	}
+	if synced, blockTime, err := transaction.IsSynced(ctx, backend, maxDelay); err == nil {
+		add(headLagDesc, time.Since(blockTime).Seconds())
+		value := 0.0
+		if synced {
+			value = 1
+		}
+		add(syncedDesc, value)
+	} else {
+		log.Debugf("failed to check chain sync: %v", err)
+		errs++
+	}
+
+	add(scrapeErrorsDesc, float64(errs))
+	return metrics
+}
+
+// toFloat converts a raw token amount into tokens.
+func toFloat(amount *big.Int, decimals int32) float64 {
+	f, _ := decimal.NewFromBigInt(amount, -decimals).Float64()
+	return f
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chequebook
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/crypto/eip712"
+)
+
+// ChequeTypes are the needed type descriptions for cheque signing
+var ChequeTypes = eip712.Types{
+	"EIP712Domain": eip712.EIP712DomainType,
+	"Cheque": []eip712.Type{
+		{
+			Name: "chequebook",
+			Type: "address",
+		},
+		{
+			Name: "beneficiary",
+			Type: "address",
+		},
+		{
+			Name: "cumulativePayout",
+			Type: "uint256",
+		},
+	},
+}
+
+// Cheque is the decoded form of a cheque as it is signed by the issuer.
+type Cheque struct {
+	Chequebook       common.Address
+	Beneficiary      common.Address
+	CumulativePayout *big.Int
+}
+
+// eip712DomainForChequebook returns the domain used for cheques on the given chain.
+func eip712DomainForChequebook(chainID *big.Int) eip712.TypedDataDomain {
+	return eip712.TypedDataDomain{
+		Name:    "Chequebook",
+		Version: "1.0",
+		ChainId: math.NewHexOrDecimal256(chainID.Int64()),
+	}
+}
+
+// ChequeTypedData creates the typed data for the cheque which is signed by the issuer.
+func ChequeTypedData(cheque *Cheque, chainID *big.Int) *eip712.TypedData {
+	return &eip712.TypedData{
+		Domain: eip712DomainForChequebook(chainID),
+		Types:  ChequeTypes,
+		Message: eip712.TypedDataMessage{
+			"chequebook":       cheque.Chequebook.Hex(),
+			"beneficiary":      cheque.Beneficiary.Hex(),
+			"cumulativePayout": (*math.HexOrDecimal256)(new(big.Int).Set(cheque.CumulativePayout)),
+		},
+		PrimaryType: "Cheque",
+	}
+}
+
+// RecoverCheque recovers the issuer ethereum address from a signed cheque.
+func RecoverCheque(cheque *Cheque, signature []byte, chainID *big.Int) (common.Address, error) {
+	pubkey, err := crypto.RecoverEIP712(signature, ChequeTypedData(cheque, chainID))
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	ethAddr, err := crypto.NewEthereumAddress(*pubkey)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	var issuer common.Address
+	copy(issuer[:], ethAddr)
+	return issuer, nil
+}
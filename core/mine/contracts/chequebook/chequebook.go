@@ -54,6 +54,36 @@ func (c *ChequebookContract) PaidOut(ctx context.Context, chequebook, address co
 	return abi.ConvertType(results[0], new(big.Int)).(*big.Int), nil
 }
 
+func (c *ChequebookContract) Issuer(ctx context.Context, chequebook common.Address) (common.Address, error) {
+	callData, err := chequebookABI.Pack("issuer")
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	output, err := c.transactionService.Call(ctx, &transaction.TxRequest{
+		To:   &chequebook,
+		Data: callData,
+	})
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	results, err := chequebookABI.Unpack("issuer", output)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	if len(results) != 1 {
+		return common.Address{}, errDecodeABI
+	}
+
+	issuer, ok := abi.ConvertType(results[0], new(common.Address)).(*common.Address)
+	if !ok || issuer == nil {
+		return common.Address{}, errDecodeABI
+	}
+	return *issuer, nil
+}
+
 func (c *ChequebookContract) CashCheque(ctx context.Context, chequebook, recipient common.Address,
 	cumulativePayout *big.Int, signature []byte) (common.Hash, error) {
 	callData, err := chequebookABI.Pack("cashCheque", recipient, cumulativePayout, signature)
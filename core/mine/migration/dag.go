@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	ipldselector "github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"time"
+)
+
+const (
+	// dagProgressInterval is how often the progress of a DAG migration is
+	// reported.
+	dagProgressInterval = 10 * time.Second
+)
+
+var (
+	ErrGraphsyncDisabled = errors.New("graphsync is not enabled, set Experimental.GraphsyncEnabled")
+)
+
+// DagProgress is the progress of the migration of a DAG.
+type DagProgress struct {
+	Blocks uint64
+	Bytes  uint64
+}
+
+// selectAll selects the whole DAG under the root.
+var selectAll ipld.Node = func() ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	return ssb.ExploreRecursive(
+		ipldselector.RecursionLimitNone(),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+	).Node()
+}()
+
+// selectBlock selects the root block alone.
+var selectBlock ipld.Node = builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+
+// DecodeSelector decodes a dag-cbor encoded graphsync selector, an empty
+// selector selects the whole DAG.
+func DecodeSelector(data []byte) (ipld.Node, error) {
+	if len(data) == 0 {
+		return selectAll, nil
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	node := nb.Build()
+	if _, err := ipldselector.ParseSelector(node); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	return node, nil
+}
+
+func DagTaskKey(fromAnt peer.ID, root cid.Cid) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%s/dag/%s", queuePrefix, fromAnt.String(), root.String()))
+}
+
+// DagSupported returns whether DAGs can be migrated, which needs graphsync.
+func (m *Migrator) DagSupported() bool {
+	return m.graphExchange != nil
+}
+
+// AsyncMigrateDag queues the migration of the DAG selected from the root.
+// With recursive the root is pinned recursively, otherwise every fetched
+// block is pinned directly.
+func (m *Migrator) AsyncMigrateDag(fromAnt peer.AddrInfo, root cid.Cid, selector []byte, recursive bool) {
+	key := DagTaskKey(fromAnt.ID, root)
+	addrs := make([]string, 0, len(fromAnt.Addrs))
+	for _, addr := range fromAnt.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	m.lock.Lock()
+	if _, ok := m.tasks[key]; ok {
+		m.lock.Unlock()
+		return
+	}
+	now := time.Now().UnixNano()
+	task := &Task{
+		FromAnt:     fromAnt.ID.String(),
+		Addrs:       addrs,
+		Cid:         root.String(),
+		Dag:         true,
+		Selector:    selector,
+		Recursive:   recursive,
+		NextAttempt: now,
+		Created:     now,
+		key:         key,
+	}
+	if err := m.stateStore.Put(key, task); err != nil {
+		log.Errorf("failed to queue migration of dag %v: %v", root, err)
+	}
+	m.add(task)
+	m.lock.Unlock()
+
+	m.notify()
+}
+
+// migrateDag pulls the DAG from the source ant with graphsync, reserves its
+// storage and pins it. It returns the sizes of the blocks pinned directly,
+// nil when the root is pinned recursively.
+func (m *Migrator) migrateDag(ctx context.Context, ant peer.AddrInfo, root cid.Cid, task *Task) (DagProgress, map[cid.Cid]uint64, error) {
+	var progress DagProgress
+	if m.graphExchange == nil {
+		return progress, nil, ErrGraphsyncDisabled
+	}
+	selector, err := DecodeSelector(task.Selector)
+	if err != nil {
+		return progress, nil, err
+	}
+	if err := m.connect(ctx, ant); err != nil {
+		return progress, nil, err
+	}
+
+	fctx, cancel := context.WithTimeout(ctx, m.config.DagTimeout)
+	defer cancel()
+	responses, errs := m.graphExchange.Request(fctx, ant.ID, cidlink.Link{Cid: root}, selector)
+
+	fetched := make(map[cid.Cid]uint64)
+	ticker := time.NewTicker(dagProgressInterval)
+	defer ticker.Stop()
+	for responses != nil || errs != nil {
+		select {
+		case resp, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			link, ok := resp.LastBlock.Link.(cidlink.Link)
+			if !ok {
+				continue
+			}
+			if _, ok := fetched[link.Cid]; ok {
+				continue
+			}
+			size, err := m.blockService.Blockstore().GetSize(link.Cid)
+			if err != nil {
+				return progress, nil, err
+			}
+			fetched[link.Cid] = uint64(size)
+			progress.Blocks++
+			progress.Bytes += uint64(size)
+			migratedBytes.WithLabelValues(kindDag).Add(float64(size))
+			if err := m.limiter.wait(fctx, size); err != nil {
+				return progress, nil, err
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				return progress, nil, err
+			}
+		case <-ticker.C:
+			m.notifier.OnMigrateDagProgress(ant.ID, root, progress)
+		case <-fctx.Done():
+			return progress, nil, fctx.Err()
+		}
+	}
+	if _, ok := fetched[root]; !ok {
+		return progress, nil, fmt.Errorf("dag %v not sent by %v", root, ant.ID)
+	}
+
+	// the fetched blocks are not pinned yet, the garbage collection of the
+	// repo reclaims them if they do not fit
+	if err := m.storage.ReserveStorage(progress.Bytes); err != nil {
+		return progress, nil, err
+	}
+	blocks, err := m.pinDag(ctx, root, fetched, task.Recursive)
+	if err != nil {
+		m.storage.ReleaseStorage(progress.Bytes)
+		return progress, nil, err
+	}
+	return progress, blocks, nil
+}
+
+// pinDag pins the root recursively, or every fetched block directly.
+func (m *Migrator) pinDag(ctx context.Context, root cid.Cid, fetched map[cid.Cid]uint64, recursive bool) (map[cid.Cid]uint64, error) {
+	if recursive {
+		node, err := m.dagService.Get(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.pinning.Pin(ctx, node, true); err != nil {
+			return nil, err
+		}
+		return nil, m.pinning.Flush(ctx)
+	}
+	for c := range fetched {
+		m.pinning.PinWithMode(c, pin.Direct)
+	}
+	return fetched, m.pinning.Flush(ctx)
+}
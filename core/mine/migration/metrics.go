@@ -0,0 +1,56 @@
+package migration
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	kindBlock = "block"
+	kindDag   = "dag"
+)
+
+var (
+	migrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "migrations_total",
+		Help:      "Number of migration attempts by kind and result, retry is a failed attempt which is retried.",
+	}, []string{"kind", "result"})
+
+	migrationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "attempt_duration_seconds",
+		Help:      "Duration of the migration attempts by kind.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 3, 10),
+	}, []string{"kind"})
+
+	migratedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "fetched_bytes_total",
+		Help:      "Number of bytes fetched from other ants by kind.",
+	}, []string{"kind"})
+
+	sourceFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "source_fallbacks_total",
+		Help:      "Number of blocks looked up with the content discovery after the source ant failed.",
+	})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "queue_depth",
+		Help:      "Number of queued migrations.",
+	})
+
+	runningMigrations = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "migration",
+		Name:      "running",
+		Help:      "Number of migrations being fetched.",
+	})
+)
@@ -1,13 +1,30 @@
 package migration
 
 import (
+	"container/heap"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	proto "github.com/antnest-network/ant-proto"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-graphsync"
 	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/go-merkledag"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
-	proto "github.com/antnest-network/ant-proto"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/mr-tron/base58/base58"
+	ma "github.com/multiformats/go-multiaddr"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,53 +35,190 @@ func init() {
 	logging.SetLogLevel("migration", "info")
 }
 
+const (
+	queuePrefix = "/migration/queue/"
+
+	maxAttempts = 5
+	minBackoff  = time.Minute
+	maxBackoff  = time.Hour
+	// idleWait is how long the queue waits for new tasks when none is due.
+	idleWait = time.Minute
+	// connectTimeout bounds the dial of the source ant.
+	connectTimeout = 30 * time.Second
+)
+
+var (
+	errNoAntAddrs = errors.New("no known address of the source ant")
+)
+
+// Config limits the concurrency and the bandwidth of the migrations.
+type Config struct {
+	// Workers is the number of blocks fetched concurrently.
+	Workers int
+	// PerAntConcurrency is the number of blocks fetched concurrently from
+	// the same source ant.
+	PerAntConcurrency int
+	// BlockTimeout bounds the fetch of a single block.
+	BlockTimeout time.Duration
+	// SourceTimeout bounds the fetch from the source ant, before the block
+	// is looked up with the general content discovery.
+	SourceTimeout time.Duration
+	// DagTimeout bounds the fetch of a DAG.
+	DagTimeout time.Duration
+	// BytesPerSecond caps the bandwidth of all workers, 0 means no cap.
+	BytesPerSecond uint64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Workers:           8,
+		PerAntConcurrency: 2,
+		BlockTimeout:      2 * time.Minute,
+		SourceTimeout:     time.Minute,
+		DagTimeout:        time.Hour,
+	}
+}
+
 type Notifier interface {
 	OnMigrateBlockDone(ant peer.ID, block cid.Cid, code int) error
+	OnMigrateDagProgress(ant peer.ID, root cid.Cid, progress DagProgress)
+	// OnMigrateDagDone reports the outcome of a DAG migration, blocks are the
+	// sizes of the blocks pinned directly, nil if the root was pinned
+	// recursively or the migration failed.
+	OnMigrateDagDone(ant peer.ID, root cid.Cid, code int, progress DagProgress, blocks map[cid.Cid]uint64) error
+	// OnMigrateInvalid reports the failure of a migration whose ant or cid
+	// does not decode. ant is the raw id of the ant and c the cid as queued.
+	OnMigrateInvalid(ant peer.ID, c string, dag bool) error
+}
+
+// Storage accounts for the space taken by the migrated DAGs, whose blocks
+// are only pinned once their size fits.
+type Storage interface {
+	ReserveStorage(size uint64) error
+	ReleaseStorage(size uint64)
+}
+
+// Task is a queued migration of a block from another ant. It stays in the
+// queue until it succeeded or failed maxAttempts times. Addrs are the
+// addresses of the ant supplied by the queen, if any. A DAG migration
+// fetches the blocks selected by the dag-cbor encoded Selector from the
+// root Cid, an empty selector selects the whole DAG.
+type Task struct {
+	FromAnt     string
+	Addrs       []string
+	Cid         string
+	Dag         bool   `json:",omitempty"`
+	Selector    []byte `json:",omitempty"`
+	Recursive   bool   `json:",omitempty"`
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+	Created     int64
+
+	key datastore.Key
+	seq uint64 // order of arrival
 }
 
-type Block struct {
-	fromAnt peer.ID
-	cid     cid.Cid
-	Code    int
+// taskHeap orders the tasks by their next attempt.
+type taskHeap []*Task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].NextAttempt < h[j].NextAttempt }
+func (h taskHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Task))
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return task
+}
+
+func TaskKey(fromAnt peer.ID, bcid cid.Cid) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%s/%s", queuePrefix, fromAnt.String(), bcid.String()))
 }
 
 type Migrator struct {
 	notifier      Notifier
+	storage       Storage
+	host          host.Host
 	blockService  blockservice.BlockService
+	dagService    ipld.DAGService
+	graphExchange graphsync.GraphExchange
 	pinning       pin.Pinner
-	blocksRequest chan *Block
-	blocksDone    chan *Block
-	wg            sync.WaitGroup
-	cancel        context.CancelFunc
+	stateStore    statestore.StateStore
+	config        Config
+	limiter       *rateLimiter
+	workers       chan struct{}
+
+	lock    sync.Mutex
+	tasks   map[datastore.Key]*Task
+	ready   map[string][]*Task // source ant -> due tasks, oldest first
+	delayed taskHeap           // tasks backed off after a failed attempt
+	seq     uint64
+	running map[string]int // source ant -> running tasks
+	wake    chan struct{}
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
 }
 
-func NewMigrator(n Notifier, blockService blockservice.BlockService, pinning pin.Pinner) *Migrator {
+// NewMigrator creates a migrator, gs may be nil in which case DAGs cannot be
+// migrated.
+func NewMigrator(n Notifier, storage Storage, h host.Host, blockService blockservice.BlockService, gs graphsync.GraphExchange,
+	pinning pin.Pinner, stateStore statestore.StateStore, config Config) *Migrator {
+	def := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = def.Workers
+	}
+	if config.PerAntConcurrency <= 0 {
+		config.PerAntConcurrency = def.PerAntConcurrency
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = def.BlockTimeout
+	}
+	if config.SourceTimeout <= 0 || config.SourceTimeout > config.BlockTimeout {
+		config.SourceTimeout = config.BlockTimeout / 2
+	}
+	if config.DagTimeout <= 0 {
+		config.DagTimeout = def.DagTimeout
+	}
 	return &Migrator{
 		notifier:      n,
+		storage:       storage,
+		host:          h,
 		blockService:  blockService,
+		dagService:    merkledag.NewDAGService(blockService),
+		graphExchange: gs,
 		pinning:       pinning,
-		blocksRequest: make(chan *Block, 1000),
-		blocksDone:    make(chan *Block, 1000),
+		stateStore:    stateStore,
+		config:        config,
+		limiter:       newRateLimiter(config.BytesPerSecond),
+		workers:       make(chan struct{}, config.Workers),
+		tasks:         make(map[datastore.Key]*Task),
+		ready:         make(map[string][]*Task),
+		running:       make(map[string]int),
+		wake:          make(chan struct{}, 1),
 	}
 }
 
+// Start reloads the queued migrations and starts migrating.
 func (m *Migrator) Start() error {
+	if err := m.load(); err != nil {
+		return err
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	m.cancel = cancel
 
 	m.wg.Add(1)
 	go func() {
 		defer m.wg.Done()
-		for {
-			select {
-			case block := <-m.blocksRequest:
-				m.Migrate(ctx, block)
-			case block := <-m.blocksDone:
-				m.notifier.OnMigrateBlockDone(block.fromAnt, block.cid, block.Code)
-			case <-ctx.Done():
-				return
-			}
-		}
+		m.loop(ctx)
 	}()
 	return nil
 }
@@ -77,29 +231,388 @@ func (m *Migrator) Stop() error {
 	return nil
 }
 
-func (m *Migrator) AsyncMigrate(fromAnt peer.ID, bcid cid.Cid) {
-	m.blocksRequest <- &Block{
-		fromAnt: fromAnt,
-		cid:     bcid,
+func (m *Migrator) load() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var list []*Task
+	err := m.stateStore.Iterate(queuePrefix, func(key string, value []byte) (stop bool, err error) {
+		task := Task{}
+		if err := json.Unmarshal(value, &task); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		task.key = datastore.NewKey(key)
+		list = append(list, &task)
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Created < list[j].Created
+	})
+	for _, task := range list {
+		m.add(task)
+	}
+	return nil
+}
+
+// add queues a new task after the others.
+func (m *Migrator) add(task *Task) {
+	m.seq++
+	task.seq = m.seq
+	m.tasks[task.key] = task
+	queueDepth.Set(float64(len(m.tasks)))
+	m.enqueue(task)
+}
+
+// enqueue puts the task in the ready queue of its ant if it is due, or in
+// the delayed tasks otherwise.
+func (m *Migrator) enqueue(task *Task) {
+	if task.NextAttempt <= time.Now().UnixNano() {
+		m.ready[task.FromAnt] = append(m.ready[task.FromAnt], task)
+		return
+	}
+	heap.Push(&m.delayed, task)
+}
+
+// AsyncMigrate queues the migration of the block from the ant. A block which
+// is already queued for the ant is not queued again, but takes the new
+// addresses of the ant.
+func (m *Migrator) AsyncMigrate(fromAnt peer.AddrInfo, bcid cid.Cid) {
+	key := TaskKey(fromAnt.ID, bcid)
+	addrs := make([]string, 0, len(fromAnt.Addrs))
+	for _, addr := range fromAnt.Addrs {
+		addrs = append(addrs, addr.String())
+	}
+	m.lock.Lock()
+	if task, ok := m.tasks[key]; ok {
+		if len(addrs) > 0 {
+			task.Addrs = addrs
+			if err := m.stateStore.Put(key, task); err != nil {
+				log.Errorf("failed to save migration of %v: %v", bcid, err)
+			}
+		}
+		m.lock.Unlock()
+		return
+	}
+	now := time.Now().UnixNano()
+	task := &Task{
+		FromAnt:     fromAnt.ID.String(),
+		Addrs:       addrs,
+		Cid:         bcid.String(),
+		NextAttempt: now,
+		Created:     now,
+		key:         key,
+	}
+	if err := m.stateStore.Put(key, task); err != nil {
+		log.Errorf("failed to queue migration of %v: %v", bcid, err)
+	}
+	m.add(task)
+	m.lock.Unlock()
+
+	m.notify()
+}
+
+// notify wakes up the dispatcher.
+func (m *Migrator) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// QueueLength returns the number of queued migrations.
+func (m *Migrator) QueueLength() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.tasks)
+}
+
+// loop hands the due tasks to the workers.
+func (m *Migrator) loop(ctx context.Context) {
+	for {
+		select {
+		case m.workers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		key, task, wait := m.next()
+		if task != nil {
+			m.wg.Add(1)
+			go func() {
+				defer m.wg.Done()
+				m.run(ctx, key, task)
+				m.release(task)
+				<-m.workers
+				m.notify()
+			}()
+			continue
+		}
+		<-m.workers
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-m.wake:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// next returns the oldest due task whose source ant has a free slot, or how
+// long to wait for one. The returned task leaves the queue while it runs.
+func (m *Migrator) next() (datastore.Key, *Task, time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now().UnixNano()
+	for m.delayed.Len() > 0 && m.delayed[0].NextAttempt <= now {
+		task := heap.Pop(&m.delayed).(*Task)
+		m.ready[task.FromAnt] = append(m.ready[task.FromAnt], task)
+	}
+	var task *Task
+	for ant, queue := range m.ready {
+		if m.running[ant] >= m.config.PerAntConcurrency {
+			continue
+		}
+		if task == nil || queue[0].seq < task.seq {
+			task = queue[0]
+		}
+	}
+	if task != nil {
+		queue := m.ready[task.FromAnt]
+		queue[0] = nil
+		if len(queue) == 1 {
+			delete(m.ready, task.FromAnt)
+		} else {
+			m.ready[task.FromAnt] = queue[1:]
+		}
+		m.running[task.FromAnt]++
+		runningMigrations.Inc()
+		return task.key, task, 0
+	}
+
+	wait := idleWait
+	if m.delayed.Len() > 0 {
+		if d := time.Duration(m.delayed[0].NextAttempt - now); d < wait {
+			wait = d
+		}
+	}
+	return datastore.Key{}, nil, wait
+}
+
+// release frees the slot of the task's ant and queues the task again if it
+// is to be retried.
+func (m *Migrator) release(task *Task) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.running[task.FromAnt]--
+	runningMigrations.Dec()
+	if m.running[task.FromAnt] <= 0 {
+		delete(m.running, task.FromAnt)
+	}
+	if _, ok := m.tasks[task.key]; ok {
+		m.enqueue(task)
+	}
+}
+
+// run makes an attempt at the task. The outcome is reported once the task
+// succeeded or ran out of attempts, failed attempts are retried with an
+// exponential back-off.
+func (m *Migrator) run(ctx context.Context, key datastore.Key, task *Task) {
+	fromAnt, err := peer.Decode(task.FromAnt)
+	if err != nil {
+		log.Errorf("migration of %v from invalid ant %v failed: %v", task.Cid, task.FromAnt, err)
+		m.fail(key, task)
+		return
+	}
+	bcid, err := cid.Decode(task.Cid)
+	if err != nil {
+		log.Errorf("migration of invalid cid %v from %v failed: %v", task.Cid, task.FromAnt, err)
+		m.fail(key, task)
+		return
+	}
+
+	m.lock.Lock()
+	ant := peer.AddrInfo{ID: fromAnt}
+	for _, v := range task.Addrs {
+		addr, err := ma.NewMultiaddr(v)
+		if err != nil {
+			log.Errorf("NewMultiaddr: %v", err)
+			continue
+		}
+		ant.Addrs = append(ant.Addrs, addr)
+	}
+	m.lock.Unlock()
+
+	var progress DagProgress
+	var blocks map[cid.Cid]uint64
+	kind := kindBlock
+	start := time.Now()
+	if task.Dag {
+		kind = kindDag
+		progress, blocks, err = m.migrateDag(ctx, ant, bcid, task)
+	} else {
+		err = m.migrate(ctx, ant, bcid)
+	}
+	if ctx.Err() != nil {
+		// stopped, the task is resumed on the next start
+		return
 	}
+	migrationDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	code := proto.Success
+	if err != nil {
+		m.lock.Lock()
+		task.Attempts++
+		task.LastError = err.Error()
+		if task.Attempts < maxAttempts {
+			backoff := minBackoff << uint(task.Attempts-1)
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			task.NextAttempt = time.Now().Add(backoff).UnixNano()
+			if err := m.stateStore.Put(key, task); err != nil {
+				log.Errorf("failed to save migration of %v: %v", task.Cid, err)
+			}
+			m.lock.Unlock()
+			migrations.WithLabelValues(kind, "retry").Inc()
+			log.Warnf("migration of %v from %v failed, attempt %v of %v, retrying in %v: %v",
+				task.Cid, task.FromAnt, task.Attempts, maxAttempts, backoff, err)
+			return
+		}
+		m.lock.Unlock()
+		log.Errorf("migration of %v from %v failed after %v attempts: %v", task.Cid, task.FromAnt, task.Attempts, err)
+		code = proto.Failure
+		migrations.WithLabelValues(kind, "failure").Inc()
+	} else {
+		migrations.WithLabelValues(kind, "success").Inc()
+	}
+	m.remove(key)
+	if task.Dag {
+		if code != proto.Success {
+			blocks = nil
+		}
+		m.notifier.OnMigrateDagDone(fromAnt, bcid, code, progress, blocks)
+		return
+	}
+	m.notifier.OnMigrateBlockDone(fromAnt, bcid, code)
 }
 
-func (m *Migrator) Migrate(ctx context.Context, block *Block) error {
-	if has, _ := m.blockService.Blockstore().Has(block.cid); has {
-		m.pinning.PinWithMode(block.cid, pin.Direct)
-		m.blocksDone <- block
+// fail removes a task which cannot be attempted and reports it as failed.
+func (m *Migrator) fail(key datastore.Key, task *Task) {
+	kind := kindBlock
+	if task.Dag {
+		kind = kindDag
+	}
+	migrations.WithLabelValues(kind, "failure").Inc()
+	m.remove(key)
+	// the text form of an id which does not decode still holds its bytes
+	ant, err := base58.Decode(task.FromAnt)
+	if err != nil {
+		log.Errorf("invalid ant %v: %v", task.FromAnt, err)
+	}
+	m.notifier.OnMigrateInvalid(peer.ID(ant), task.Cid, task.Dag)
+}
+
+func (m *Migrator) remove(key datastore.Key) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.tasks, key)
+	queueDepth.Set(float64(len(m.tasks)))
+	if err := m.stateStore.Delete(key); err != nil {
+		log.Errorf("failed to remove migration %v: %v", key, err)
+	}
+}
+
+func (m *Migrator) migrate(ctx context.Context, ant peer.AddrInfo, bcid cid.Cid) error {
+	if has, _ := m.blockService.Blockstore().Has(bcid); has {
+		m.pinning.PinWithMode(bcid, pin.Direct)
 		return nil
 	}
-	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	reserved, err := m.limiter.reserve(ctx)
+	if err != nil {
+		return err
+	}
+	fctx, cancel := context.WithTimeout(ctx, m.config.BlockTimeout)
 	defer cancel()
-	_, err := m.blockService.GetBlock(ctx, block.cid)
+	block, err := m.fetchFromAnt(fctx, ant, bcid)
+	if err != nil {
+		if fctx.Err() != nil {
+			m.limiter.done(reserved, 0)
+			return err
+		}
+		log.Warnf("failed to get block %v from %v, falling back to content discovery: %v", bcid, ant.ID, err)
+		sourceFallbacks.Inc()
+		block, err = m.blockService.GetBlock(fctx, bcid)
+	}
 	if err != nil {
-		log.Errorf("failed to get block %v: %v", block.cid.String(), err)
-		block.Code = proto.Failure
-		m.blocksDone <- block
+		m.limiter.done(reserved, 0)
+		log.Errorf("failed to get block %v: %v", bcid.String(), err)
 		return err
 	}
-	m.pinning.PinWithMode(block.cid, pin.Direct)
-	m.blocksDone <- block
+	m.limiter.done(reserved, len(block.RawData()))
+	m.pinning.PinWithMode(bcid, pin.Direct)
+	migratedBytes.WithLabelValues(kindBlock).Add(float64(len(block.RawData())))
 	return nil
 }
+
+// fetchFromAnt connects to the source ant and requests the block from it
+// alone with graphsync. Without graphsync the block is fetched by a bitswap
+// session, which asks the ant as it is connected but asks the other peers
+// too.
+func (m *Migrator) fetchFromAnt(ctx context.Context, ant peer.AddrInfo, bcid cid.Cid) (blocks.Block, error) {
+	sctx, cancel := context.WithTimeout(ctx, m.config.SourceTimeout)
+	defer cancel()
+
+	if err := m.connect(sctx, ant); err != nil {
+		return nil, err
+	}
+	if m.graphExchange == nil {
+		return blockservice.NewSession(sctx, m.blockService).GetBlock(sctx, bcid)
+	}
+	responses, errs := m.graphExchange.Request(sctx, ant.ID, cidlink.Link{Cid: bcid}, selectBlock)
+	for responses != nil || errs != nil {
+		select {
+		case _, ok := <-responses:
+			if !ok {
+				responses = nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("request to %v: %w", ant.ID, err)
+			}
+		case <-sctx.Done():
+			return nil, sctx.Err()
+		}
+	}
+	block, err := m.blockService.Blockstore().Get(bcid)
+	if err != nil {
+		return nil, fmt.Errorf("block not sent by %v: %w", ant.ID, err)
+	}
+	return block, nil
+}
+
+// connect connects to the source ant, with the addresses supplied by the
+// queen or those in the peerstore.
+func (m *Migrator) connect(ctx context.Context, ant peer.AddrInfo) error {
+	if m.host.Network().Connectedness(ant.ID) == network.Connected {
+		return nil
+	}
+	if len(ant.Addrs) > 0 {
+		m.host.Peerstore().AddAddrs(ant.ID, ant.Addrs, peerstore.TempAddrTTL)
+	}
+	if len(m.host.Peerstore().Addrs(ant.ID)) == 0 {
+		return errNoAntAddrs
+	}
+	cctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return m.host.Connect(cctx, peer.AddrInfo{ID: ant.ID})
+}
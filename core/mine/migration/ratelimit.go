@@ -0,0 +1,97 @@
+package migration
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// initialBlockEstimate is the size reserved for a block before any block
+	// was fetched, the default chunk size of unixfs.
+	initialBlockEstimate = 256 << 10
+
+	// estimateWeight is the weight of the latest block in the average size.
+	estimateWeight = 0.2
+)
+
+// rateLimiter spaces out the fetched blocks so that their total size stays
+// under the configured bytes per second.
+type rateLimiter struct {
+	bytesPerSecond float64
+
+	lock     sync.Mutex
+	next     time.Time
+	estimate float64 // average size of the fetched blocks
+}
+
+func newRateLimiter(bytesPerSecond uint64) *rateLimiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		estimate:       initialBlockEstimate,
+	}
+}
+
+// reserve waits until a block fits in the rate before it is fetched, so
+// that concurrent fetches do not burst over the rate. The size of the block
+// is not known yet, the average size of the blocks is reserved and returned,
+// done corrects it once the block is fetched.
+func (l *rateLimiter) reserve(ctx context.Context) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.lock.Lock()
+	n := int(l.estimate)
+	l.lock.Unlock()
+	return n, l.wait(ctx, n)
+}
+
+// done replaces the reserved size by the size of the fetched block, 0 if it
+// was not fetched.
+func (l *rateLimiter) done(reserved, n int) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.next = l.next.Add(l.duration(n - reserved))
+	if n > 0 {
+		l.estimate = (1-estimateWeight)*l.estimate + estimateWeight*float64(n)
+	}
+}
+
+func (l *rateLimiter) duration(n int) time.Duration {
+	return time.Duration(float64(n) / l.bytesPerSecond * float64(time.Second))
+}
+
+// wait accounts for n bytes and waits until they fit in the rate. A nil
+// limiter does not limit. The blocks of a DAG are accounted once received,
+// graphsync is held back as the responses are read at the rate.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.duration(n))
+	l.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,108 @@
+package mineiface
+
+import (
+	"context"
+	"time"
+
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// Statuses of a cash-out transaction.
+const (
+	CashOutStatusPending  = "pending"
+	CashOutStatusMined    = "mined"
+	CashOutStatusReverted = "reverted"
+	CashOutStatusDropped  = "dropped"
+)
+
+// ChequeRecord is a cheque accepted from a queen. The amounts are in the
+// smallest unit of ANTZ.
+type ChequeRecord struct {
+	Chequebook       string
+	From             string
+	ReceivedAt       time.Time
+	CumulativePayout string
+	CumulativeReward string
+}
+
+// CashOut is a cash-out transaction sent by the node. Created and Updated
+// are unix times in seconds.
+type CashOut struct {
+	TxHash           string
+	Chequebook       string
+	Amount           string
+	CumulativePayout string
+	Status           string // pending, mined, reverted or dropped
+	GasUsed          uint64
+	BlockNumber      uint64
+	RevertReason     string
+	Created          int64
+	Updated          int64
+}
+
+// CashOutResult is the outcome of the cash-out of a chequebook when cashing
+// out every chequebook.
+type CashOutResult struct {
+	Chequebook string
+	Status     string
+	Amount     string
+	TxHash     string
+	Reason     string
+}
+
+// CashOutPolicy is the policy of the automatic cash-out loop.
+type CashOutPolicy struct {
+	Enabled       bool
+	Threshold     string // ANTZ
+	Interval      string
+	MaxGasPrice   string // gwei
+	MinBNBBalance string // BNB
+}
+
+// CashOutDecision is a decision taken by the automatic cash-out loop. Time
+// is a unix time in nanoseconds.
+type CashOutDecision struct {
+	Seq        uint64
+	Time       int64
+	Chequebook string
+	Action     string
+	Reason     string
+	Amount     string
+	TxHash     string
+}
+
+// AutoCashOut is the automatic cash-out policy and its recent decisions.
+type AutoCashOut struct {
+	Policy    CashOutPolicy
+	Decisions []CashOutDecision
+}
+
+// ChequeAPI extends the cheque section of the CoreAPI, the Cheque section of
+// the CoreAPI of a node running the mine service implements it.
+type ChequeAPI interface {
+	coreiface.ChequeAPI
+
+	// History returns the accepted cheques of the chequebook, or of all
+	// chequebooks if chequebook is empty, oldest first
+	History(ctx context.Context, chequebook string) ([]ChequeRecord, error)
+
+	// CashOutCheque sends the cash-out transaction of the chequebook and
+	// returns it pending
+	CashOutCheque(ctx context.Context, chequebook string) (CashOut, error)
+
+	// WaitCashOut waits until the cash-out transaction was mined, reverted
+	// or dropped
+	WaitCashOut(ctx context.Context, txHash string) (CashOut, error)
+
+	// CashOutAllResults cashes out every chequebook with an uncashed amount
+	// and returns the outcome for each chequebook
+	CashOutAllResults(ctx context.Context) ([]CashOutResult, error)
+
+	// CashOuts returns the cash-out transactions sent by the node, newest
+	// first
+	CashOuts(ctx context.Context) ([]CashOut, error)
+
+	// AutoCashOut returns the automatic cash-out policy and its decisions,
+	// oldest first
+	AutoCashOut(ctx context.Context) (AutoCashOut, error)
+}
@@ -0,0 +1,13 @@
+// Package mineiface defines the CoreAPI sections of the mine features which
+// are not part of interface-go-ipfs-core. The CoreAPI of go-ipfs implements
+// them next to the coreiface sections.
+package mineiface
+
+// MineAPI is implemented by the CoreAPI of a node running the mine service.
+type MineAPI interface {
+	// Queen returns an implementation of the Queen API
+	Queen() QueenAPI
+
+	// Event returns an implementation of the Event API
+	Event() EventAPI
+}
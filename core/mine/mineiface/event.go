@@ -0,0 +1,28 @@
+package mineiface
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Event is an event of the mine service. Data is the JSON encoded payload of
+// the event, its fields depend on Type.
+type Event struct {
+	Seq  uint64
+	Time time.Time
+	Type string
+	Data json.RawMessage
+}
+
+// EventAPI specifies the interface to the events of the mine service.
+type EventAPI interface {
+	// List returns the retained events after the sequence number, of the
+	// given types or of any type if none is given
+	List(ctx context.Context, since uint64, types ...string) ([]Event, error)
+
+	// Subscribe returns the retained events after the sequence number
+	// followed by the new events. The channel is closed when ctx is done or
+	// when the subscriber lags behind
+	Subscribe(ctx context.Context, since uint64, types ...string) (<-chan Event, error)
+}
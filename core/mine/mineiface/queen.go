@@ -0,0 +1,50 @@
+package mineiface
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// QueenError is a failed ping to a queen.
+type QueenError struct {
+	Time  time.Time
+	Error string
+}
+
+// Queen is the state of a queen known to the node.
+type Queen struct {
+	ID            peer.ID
+	Source        string // bootstrap, roster or unsigned
+	Addrs         []string
+	Connectedness string
+	LastReported  bool // whether the last report was sent to this queen
+	LastPing      time.Time
+	LastPong      time.Time
+	RTT           time.Duration
+	Pings         uint64
+	Failures      uint64
+	SuccessRate   float64
+	BackoffUntil  time.Time // the queen is not used until then after failed pings
+	LastError     string
+	RecentErrors  []QueenError
+}
+
+// QueenPing is the outcome of a ping to a queen.
+type QueenPing struct {
+	ID      peer.ID
+	Success bool
+	RTT     time.Duration
+	Error   string
+}
+
+// QueenAPI specifies the interface to the queens.
+type QueenAPI interface {
+	// List returns the active queens, from the roster or from an unsigned
+	// queen list, and the bootstrap queens with their ping health
+	List(context.Context) ([]Queen, error)
+
+	// Ping pings a known queen immediately
+	Ping(context.Context, peer.ID) (QueenPing, error)
+}
@@ -0,0 +1,132 @@
+// Package mineproto defines the mine protocols which extend ant-proto. The
+// message types of the protocols are registered with the ant-proto message
+// types by RegisterMessageTypes so they can be served by the same messenger.
+package mineproto
+
+//go:generate sh -c "protoc --proto_path=. --proto_path=$(go list -m -f '{{.Dir}}' github.com/antnest-network/ant-proto)/pb --gofast_out=paths=source_relative,Mant.proto=github.com/antnest-network/ant-proto/pb:. mine.proto"
+
+import (
+	"context"
+	antproto "github.com/antnest-network/ant-proto"
+	"github.com/gogo/protobuf/proto"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"reflect"
+	"sync"
+)
+
+var (
+	ProtocolQueenRoster protocol.ID = "/ant/queen_roster/1.0.0"
+
+	ProtocolStorageChallenge protocol.ID = "/ant/storage_challenge/1.0.0"
+	ProtocolStorageProof     protocol.ID = "/ant/storage_proof/1.0.0"
+
+	ProtocolReleaseBlocks     protocol.ID = "/ant/release_blocks/1.0.0"
+	ProtocolReleaseBlocksResp protocol.ID = "/ant/release_blocks_resp/1.0.0"
+
+	// ProtocolMigrateBlock extends the ant-proto migrate block message with
+	// the addresses of the source ant, it is answered with a
+	// MigrateBlockResp on the ant-proto protocol.
+	ProtocolMigrateBlock protocol.ID = "/ant/migrate_block/1.1.0"
+
+	// ProtocolMigrateBlockResults reports the migration results in batches,
+	// which the queen acknowledges by id on ProtocolMigrateBlockResultsAck.
+	ProtocolMigrateBlockResults    protocol.ID = "/ant/migrate_block_results/1.0.0"
+	ProtocolMigrateBlockResultsAck protocol.ID = "/ant/migrate_block_results_ack/1.0.0"
+
+	// ProtocolMigrateDag asks for the migration of DAGs, it is answered with
+	// a MigrateBlockResp on the ant-proto protocol. The progress of every
+	// root is reported on ProtocolMigrateDagProgress.
+	ProtocolMigrateDag         protocol.ID = "/ant/migrate_dag/1.0.0"
+	ProtocolMigrateDagProgress protocol.ID = "/ant/migrate_dag_progress/1.0.0"
+
+	ProtocolBlocksLost protocol.ID = "/ant/blocks_lost/1.0.0"
+
+	Protocols = []protocol.ID{
+		ProtocolQueenRoster,
+		ProtocolStorageChallenge,
+		ProtocolStorageProof,
+		ProtocolReleaseBlocks,
+		ProtocolReleaseBlocksResp,
+		ProtocolMigrateBlock,
+		ProtocolMigrateBlockResults,
+		ProtocolMigrateBlockResultsAck,
+		ProtocolMigrateDag,
+		ProtocolMigrateDagProgress,
+		ProtocolBlocksLost,
+	}
+
+	ProtocolMessageType = map[protocol.ID]reflect.Type{
+		ProtocolQueenRoster:       reflect.TypeOf(QueenRoster{}),
+		ProtocolStorageChallenge:  reflect.TypeOf(StorageChallenge{}),
+		ProtocolStorageProof:      reflect.TypeOf(StorageProof{}),
+		ProtocolReleaseBlocks:     reflect.TypeOf(ReleaseBlocksReq{}),
+		ProtocolReleaseBlocksResp: reflect.TypeOf(ReleaseBlocksResp{}),
+		ProtocolMigrateBlock:      reflect.TypeOf(MigrateBlockReq{}),
+
+		ProtocolMigrateBlockResults:    reflect.TypeOf(MigrateBlockResults{}),
+		ProtocolMigrateBlockResultsAck: reflect.TypeOf(MigrateBlockResultsAck{}),
+
+		ProtocolMigrateDag:         reflect.TypeOf(MigrateDagReq{}),
+		ProtocolMigrateDagProgress: reflect.TypeOf(MigrateDagProgress{}),
+
+		ProtocolBlocksLost: reflect.TypeOf(BlocksLost{}),
+	}
+)
+
+// MessageSender sends the messages of the protocols which have no method on
+// the ant-proto Messenger, it is implemented by *proto.AntMessenger.
+type MessageSender interface {
+	SendMessage(ctx context.Context, protocol protocol.ID, to peer.ID, msg proto.Message) error
+}
+
+var registerOnce sync.Once
+
+// RegisterMessageTypes adds the message types of the mine protocols to the
+// ant-proto message types, which the messenger decodes the messages with. It
+// must be called before the messenger serves the mine protocols.
+func RegisterMessageTypes() {
+	registerOnce.Do(func() {
+		for pid, t := range ProtocolMessageType {
+			antproto.ProtocolMessageType[pid] = t
+		}
+	})
+}
+
+// RosterSigningData returns the data the roster key signs, the encoded
+// roster without its signature.
+func RosterSigningData(roster *QueenRoster) ([]byte, error) {
+	unsigned := *roster
+	unsigned.Signature = nil
+	return proto.Marshal(&unsigned)
+}
+
+// SignQueenRoster signs the roster with the roster key.
+func SignQueenRoster(signer crypto.Signer, roster *QueenRoster) error {
+	data, err := RosterSigningData(roster)
+	if err != nil {
+		return err
+	}
+	roster.Signature, err = signer.Sign(data)
+	return err
+}
+
+// ProofSigningData returns the data the ant signs, the encoded proof without
+// its signature.
+func ProofSigningData(p *StorageProof) ([]byte, error) {
+	unsigned := *p
+	unsigned.Signature = nil
+	return proto.Marshal(&unsigned)
+}
+
+// Failure codes of PushBlockResp and ReleaseBlocksResp in addition to
+// proto.Success and proto.Failure, the queen can place a refused block on
+// another ant.
+const (
+	CodeInvalidCid    = 2
+	CodeBlockTooLarge = 3
+	CodeHashMismatch  = 4
+	CodeQuotaExceeded = 5
+	CodeNotStored     = 6
+)
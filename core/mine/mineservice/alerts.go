@@ -0,0 +1,144 @@
+package mineservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/types"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"math"
+	"math/big"
+	"time"
+)
+
+// alertMonitor checks the conditions an operator has to act on and publishes
+// an event when one starts. An alert is published again only after its
+// condition cleared.
+type alertMonitor struct {
+	chx                   chain.Chain
+	walletAddress         common.Address
+	nodeId                string
+	queenManager          *QueenManager
+	admission             *blockAdmission
+	events                *EventBus
+	interval              time.Duration
+	minBalance            *big.Int
+	queenUnreachableAfter time.Duration
+	quotaWarningFraction  float64
+
+	pinged map[peer.ID]time.Time // queen -> when it was first seen pinged
+	active map[string]bool
+}
+
+func newAlertMonitor(cfg Alerts, chx chain.Chain, walletAddress common.Address, nodeId string,
+	queenManager *QueenManager, admission *blockAdmission, events *EventBus) (*alertMonitor, error) {
+	minBalance, err := types.ParseBNB(cfg.MinBNBBalance)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert min BNB balance %q: %w", cfg.MinBNBBalance, err)
+	}
+	if cfg.QuotaWarningFraction < 0 || cfg.QuotaWarningFraction > 1 {
+		return nil, fmt.Errorf("invalid quota warning fraction %v, must be between 0 and 1", cfg.QuotaWarningFraction)
+	}
+	return &alertMonitor{
+		chx:                   chx,
+		walletAddress:         walletAddress,
+		nodeId:                nodeId,
+		queenManager:          queenManager,
+		admission:             admission,
+		events:                events,
+		interval:              parseDuration(cfg.CheckInterval, time.Minute),
+		minBalance:            minBalance,
+		queenUnreachableAfter: parseDuration(cfg.QueenUnreachableAfter, 10*time.Minute),
+		quotaWarningFraction:  cfg.QuotaWarningFraction,
+		pinged:                make(map[peer.ID]time.Time),
+		active:                make(map[string]bool),
+	}, nil
+}
+
+func (a *alertMonitor) run(ctx context.Context) {
+	now := time.Now()
+	for id := range a.queenManager.Pinged() {
+		a.pinged[id] = now
+	}
+	for sleep(ctx, a.interval) {
+		a.check(ctx)
+	}
+}
+
+// check checks every condition, a condition which cannot be checked keeps
+// its state.
+func (a *alertMonitor) check(ctx context.Context) {
+	cctx, cancel := context.WithTimeout(ctx, a.interval)
+	defer cancel()
+
+	if balance, err := a.chx.BNBBalanceOf(cctx, a.walletAddress); err == nil {
+		a.set(EventBalanceLow, "", balance.Cmp(a.minBalance) < 0, &BalanceLowEvent{
+			Address:   a.walletAddress.Hex(),
+			Balance:   types.BNB(*balance).String(),
+			Threshold: types.BNB(*a.minBalance).String(),
+		})
+	} else {
+		log.Debugf("failed to check BNB balance: %v", err)
+	}
+
+	if locked, err := a.chx.LockedAmount(cctx, a.nodeId); err == nil {
+		a.set(EventPledgeMissing, "", locked.Sign() <= 0, &PledgeMissingEvent{NodeId: a.nodeId})
+	} else {
+		log.Debugf("failed to check pledge: %v", err)
+	}
+
+	// a queen is unreachable when it did not answer since it is pinged, the
+	// bootstrap queens are not pinged while an active queen is up
+	now := time.Now()
+	pinged := a.queenManager.Pinged()
+	for _, h := range a.queenManager.Health() {
+		if !pinged[h.ID] {
+			delete(a.pinged, h.ID)
+			a.set(EventQueenUnreachable, h.ID.String(), false, nil)
+			continue
+		}
+		since, ok := a.pinged[h.ID]
+		if !ok {
+			since = now
+			a.pinged[h.ID] = since
+		}
+		if h.LastPong.After(since) {
+			since = h.LastPong
+		}
+		a.set(EventQueenUnreachable, h.ID.String(), now.Sub(since) >= a.queenUnreachableAfter, &QueenUnreachableEvent{
+			Queen:     h.ID.String(),
+			LastPong:  h.LastPong,
+			LastError: h.LastError,
+		})
+	}
+
+	if a.quotaWarningFraction > 0 {
+		usage, limit, err := a.admission.usageAndLimit()
+		if err == nil && limit != math.MaxUint64 && limit > 0 {
+			a.set(EventQuotaNearlyFull, "", float64(usage) >= a.quotaWarningFraction*float64(limit), &QuotaNearlyFullEvent{
+				Usage:    usage,
+				Limit:    limit,
+				Fraction: float64(usage) / float64(limit),
+			})
+		} else if err != nil {
+			log.Debugf("failed to check storage usage: %v", err)
+		}
+	}
+}
+
+// set records the state of the condition of the subject and publishes the
+// event when it starts.
+func (a *alertMonitor) set(typ, subject string, on bool, data interface{}) {
+	key := typ + "/" + subject
+	if on == a.active[key] {
+		return
+	}
+	if !on {
+		delete(a.active, key)
+		return
+	}
+	a.active[key] = true
+	log.Warnf("alert %v: %+v", typ, data)
+	a.events.Publish(typ, data)
+}
@@ -0,0 +1,75 @@
+package mineservice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// unavailableChain is a chain which cannot be reached.
+type unavailableChain struct {
+	chain.Chain
+}
+
+func (unavailableChain) BNBBalanceOf(ctx context.Context, account common.Address) (*big.Int, error) {
+	return nil, chain.ErrChainUnavailable
+}
+
+func (unavailableChain) LockedAmount(ctx context.Context, nodeId string) (*big.Int, error) {
+	return nil, chain.ErrChainUnavailable
+}
+
+func TestQueenUnreachableAlert(t *testing.T) {
+	active, bootstrap := peer.ID("active"), peer.ID("bootstrap")
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	events, err := NewEventBus(0, stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queenManager := NewQueenManager([]peer.AddrInfo{{ID: bootstrap}}, stateStore, common.Address{})
+	queenManager.activeQueens = []peer.AddrInfo{{ID: active}}
+
+	a, err := newAlertMonitor(Alerts{MinBNBBalance: "0", QueenUnreachableAfter: "1ms"},
+		unavailableChain{}, common.Address{}, "node", queenManager, nil, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := func() map[string]bool {
+		ret := make(map[string]bool)
+		for _, e := range events.Events(0, EventQueenUnreachable) {
+			ret[e.Data.(*QueenUnreachableEvent).Queen] = true
+		}
+		return ret
+	}
+
+	// the bootstrap queen is not pinged while the active queen is up
+	a.check(context.Background())
+	time.Sleep(5 * time.Millisecond)
+	queenManager.ReportPing(active, time.Millisecond, nil)
+	a.check(context.Background())
+	if got := unreachable(); got[bootstrap.String()] || got[active.String()] {
+		t.Fatalf("unexpected alerts %v", got)
+	}
+
+	// the active queen goes down, the bootstrap queen is then pinged
+	queenManager.ReportPing(active, 0, errors.New("timeout"))
+	time.Sleep(5 * time.Millisecond)
+	a.check(context.Background())
+	if got := unreachable(); !got[active.String()] || got[bootstrap.String()] {
+		t.Fatalf("unexpected alerts %v", got)
+	}
+	time.Sleep(5 * time.Millisecond)
+	a.check(context.Background())
+	if got := unreachable(); !got[bootstrap.String()] {
+		t.Fatalf("the bootstrap queen was not reported, alerts %v", got)
+	}
+}
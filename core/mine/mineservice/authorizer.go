@@ -0,0 +1,53 @@
+package mineservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	proto "github.com/antnest-network/ant-proto"
+)
+
+// Authorizer decides which peers may send mine protocol messages. Only the
+// bootstrap queens, the queens they have vouched for and the peers of the
+// allow-list are accepted.
+type Authorizer struct {
+	queenManager *QueenManager
+	allowed      map[peer.ID]struct{}
+}
+
+func NewAuthorizer(queenManager *QueenManager, allowedPeers []string) (*Authorizer, error) {
+	a := &Authorizer{
+		queenManager: queenManager,
+		allowed:      make(map[peer.ID]struct{}),
+	}
+	for _, v := range allowedPeers {
+		id, err := peer.Decode(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed peer %q: %w", v, err)
+		}
+		a.allowed[id] = struct{}{}
+	}
+	return a, nil
+}
+
+// Authorized reports whether messages from the peer are accepted.
+func (a *Authorizer) Authorized(p peer.ID) bool {
+	if _, ok := a.allowed[p]; ok {
+		return true
+	}
+	return a.queenManager.IsQueen(p)
+}
+
+// Wrap returns a message handler which drops the messages of unauthorized
+// peers before they reach h.
+func (a *Authorizer) Wrap(pid protocol.ID, h proto.MessageHandler) proto.MessageHandler {
+	return func(ctx context.Context, from peer.ID, msg interface{}) {
+		if !a.Authorized(from) {
+			log.Warnf("rejected %v message from unauthorized peer %v", pid, from)
+			unauthorizedMessages.WithLabelValues(string(pid)).Inc()
+			return
+		}
+		h(ctx, from, msg)
+	}
+}
@@ -0,0 +1,255 @@
+package mineservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/ipfs/go-ipfs/core/mine/types"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	cashOutDecisionPrefix = "/autocashout/decision/"
+	cashOutLastRunKey     = "/autocashout/lastrun"
+
+	cashOutCheckInterval = 10 * time.Minute
+	maxCashOutDecisions  = 1000
+)
+
+const (
+	CashOutActionCashOut = "cashout"
+	CashOutActionSkip    = "skip"
+	CashOutActionError   = "error"
+)
+
+// CashOutDecision is a decision taken by the automatic cash-out loop. An
+// empty chequebook means the decision applies to the whole run. The
+// decisions are numbered in the order they are taken.
+type CashOutDecision struct {
+	Seq        uint64
+	Time       int64
+	Chequebook string
+	Action     string
+	Reason     string
+	Amount     string
+	TxHash     string
+}
+
+type autoCashOut struct {
+	threshold     *big.Int
+	interval      time.Duration
+	maxGasPrice   *big.Int
+	minBNBBalance *big.Int
+
+	chequeManager *ChequeManager
+	chain         chain.Chain
+	signer        crypto.Signer
+	stateStore    statestore.StateStore
+	seq           uint64 // sequence of the last decision
+}
+
+func CashOutDecisionKey(seq uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%020d", cashOutDecisionPrefix, seq))
+}
+
+func newAutoCashOut(policy CashOutPolicy, chequeManager *ChequeManager, chx chain.Chain,
+	signer crypto.Signer, stateStore statestore.StateStore) (*autoCashOut, error) {
+	threshold, err := types.ParseAntz(policy.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cash-out threshold %q: %w", policy.Threshold, err)
+	}
+	gasPrice, err := decimal.NewFromString(policy.MaxGasPrice)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cash-out max gas price %q: %w", policy.MaxGasPrice, err)
+	}
+	minBNBBalance, err := types.ParseBNB(policy.MinBNBBalance)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cash-out min BNB balance %q: %w", policy.MinBNBBalance, err)
+	}
+	a := &autoCashOut{
+		threshold:     threshold,
+		interval:      parseDuration(policy.Interval, 24*time.Hour),
+		maxGasPrice:   gasPrice.Shift(9).BigInt(),
+		minBNBBalance: minBNBBalance,
+		chequeManager: chequeManager,
+		chain:         chx,
+		signer:        signer,
+		stateStore:    stateStore,
+	}
+	if err := a.loadSeq(); err != nil {
+		return nil, fmt.Errorf("failed to load cash-out decisions: %w", err)
+	}
+	return a, nil
+}
+
+// loadSeq resumes the sequence of the decisions. The decisions saved under
+// their time before they were numbered are numbered in time order, only the
+// last maxCashOutDecisions are kept.
+func (a *autoCashOut) loadSeq() error {
+	decisions, err := a.decisions()
+	if err != nil {
+		return err
+	}
+	var legacy []*CashOutDecision
+	for _, decision := range decisions {
+		if decision.Seq == 0 {
+			legacy = append(legacy, decision)
+		} else if decision.Seq > a.seq {
+			a.seq = decision.Seq
+		}
+	}
+	for i, decision := range legacy {
+		key := datastore.NewKey(fmt.Sprintf("%s%020d", cashOutDecisionPrefix, decision.Time))
+		if err := a.stateStore.Delete(key); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+		if len(legacy)-i > maxCashOutDecisions {
+			continue
+		}
+		a.seq++
+		decision.Seq = a.seq
+		if err := a.stateStore.Put(CashOutDecisionKey(decision.Seq), decision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *autoCashOut) loop(ctx context.Context) {
+	ticker := time.NewTicker(cashOutCheckInterval)
+	defer ticker.Stop()
+	for {
+		a.maybeRun(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *autoCashOut) maybeRun(ctx context.Context) {
+	var lastRun int64
+	err := a.stateStore.Get(datastore.NewKey(cashOutLastRunKey), &lastRun)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		log.Errorf("failed to get last cash-out run: %v", err)
+		return
+	}
+	if time.Since(time.Unix(0, lastRun)) < a.interval {
+		return
+	}
+	if !a.run(ctx) {
+		return
+	}
+	err = a.stateStore.Put(datastore.NewKey(cashOutLastRunKey), time.Now().UnixNano())
+	if err != nil {
+		log.Errorf("failed to save last cash-out run: %v", err)
+	}
+}
+
+// run does a single cash-out run and reports whether it was carried out.
+func (a *autoCashOut) run(ctx context.Context) bool {
+	gasPrice, err := a.chain.Backend().SuggestGasPrice(ctx)
+	if err != nil {
+		a.record(&CashOutDecision{Action: CashOutActionError, Reason: fmt.Sprintf("failed to get gas price: %v", err)})
+		return false
+	}
+	if gasPrice.Cmp(a.maxGasPrice) > 0 {
+		a.record(&CashOutDecision{Action: CashOutActionSkip, Reason: fmt.Sprintf("gas price %v above ceiling %v", gasPrice, a.maxGasPrice)})
+		return false
+	}
+
+	ethAddress, err := a.signer.EthereumAddress()
+	if err != nil {
+		a.record(&CashOutDecision{Action: CashOutActionError, Reason: err.Error()})
+		return false
+	}
+	balance, err := a.chain.BNBBalanceOf(ctx, ethAddress)
+	if err != nil {
+		a.record(&CashOutDecision{Action: CashOutActionError, Reason: fmt.Sprintf("failed to get BNB balance: %v", err)})
+		return false
+	}
+	if balance.Cmp(a.minBNBBalance) < 0 {
+		a.record(&CashOutDecision{Action: CashOutActionSkip, Reason: fmt.Sprintf("BNB balance %v below reserve %v",
+			types.NBNFromRawString(balance.String()), types.NBNFromRawString(a.minBNBBalance.String()))})
+		return false
+	}
+
+	cheques, err := a.chequeManager.chequeStore.GetCheques()
+	if err != nil {
+		a.record(&CashOutDecision{Action: CashOutActionError, Reason: fmt.Sprintf("failed to get cheques: %v", err)})
+		return false
+	}
+	for _, cheque := range cheques {
+		uncashed, err := a.chequeManager.Uncashed(ctx, cheque)
+		if err != nil {
+			a.record(&CashOutDecision{Chequebook: cheque.Chequebook, Action: CashOutActionError, Reason: err.Error()})
+			continue
+		}
+		if uncashed.Cmp(a.threshold) < 0 {
+			a.record(&CashOutDecision{Chequebook: cheque.Chequebook, Action: CashOutActionSkip,
+				Reason: "uncashed amount below threshold", Amount: uncashed.String()})
+			continue
+		}
+		txHash, err := a.chequeManager.CashOutCheque(ctx, cheque.Chequebook)
+		if err != nil {
+			a.record(&CashOutDecision{Chequebook: cheque.Chequebook, Action: CashOutActionError,
+				Reason: err.Error(), Amount: uncashed.String()})
+			continue
+		}
+		a.record(&CashOutDecision{Chequebook: cheque.Chequebook, Action: CashOutActionCashOut,
+			Amount: uncashed.String(), TxHash: txHash.Hex()})
+	}
+	return true
+}
+
+func (a *autoCashOut) record(decision *CashOutDecision) {
+	decision.Time = time.Now().UnixNano()
+	log.Infof("auto cash-out: action=%v chequebook=%v amount=%v tx=%v reason=%v", decision.Action,
+		decision.Chequebook, decision.Amount, decision.TxHash, decision.Reason)
+	a.seq++
+	decision.Seq = a.seq
+	if err := a.stateStore.Put(CashOutDecisionKey(decision.Seq), decision); err != nil {
+		log.Errorf("failed to save cash-out decision: %v", err)
+		return
+	}
+	// only the last maxCashOutDecisions decisions are kept
+	if decision.Seq > maxCashOutDecisions {
+		err := a.stateStore.Delete(CashOutDecisionKey(decision.Seq - maxCashOutDecisions))
+		if err != nil && err != datastore.ErrNotFound {
+			log.Errorf("failed to delete cash-out decision: %v", err)
+		}
+	}
+}
+
+// decisions returns the recorded decisions ordered from oldest to newest.
+func (a *autoCashOut) decisions() ([]*CashOutDecision, error) {
+	var list []*CashOutDecision
+	err := a.stateStore.Iterate(cashOutDecisionPrefix, func(key string, value []byte) (stop bool, err error) {
+		decision := CashOutDecision{}
+		if err := json.Unmarshal(value, &decision); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &decision)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Seq != list[j].Seq {
+			return list[i].Seq < list[j].Seq
+		}
+		return list[i].Time < list[j].Time
+	})
+	return list, nil
+}
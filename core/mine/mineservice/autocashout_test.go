@@ -0,0 +1,63 @@
+package mineservice
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+)
+
+func TestCashOutDecisions(t *testing.T) {
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	// decisions saved under their time before they were numbered
+	for _, tm := range []int64{300, 100, 200} {
+		key := datastore.NewKey(fmt.Sprintf("%s%020d", cashOutDecisionPrefix, tm))
+		err := stateStore.Put(key, &CashOutDecision{Time: tm, Action: CashOutActionSkip})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &autoCashOut{stateStore: stateStore}
+	if err := a.loadSeq(); err != nil {
+		t.Fatal(err)
+	}
+	if a.seq != 3 {
+		t.Fatalf("sequence is %d after the legacy decisions, expected 3", a.seq)
+	}
+	decisions, err := a.decisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, decision := range decisions {
+		if decision.Seq != uint64(i+1) || decision.Time != int64(i+1)*100 {
+			t.Fatalf("unexpected decision %d: %+v", i, decision)
+		}
+	}
+
+	for i := 0; i < maxCashOutDecisions; i++ {
+		a.record(&CashOutDecision{Action: CashOutActionSkip})
+	}
+	decisions, err = a.decisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != maxCashOutDecisions {
+		t.Fatalf("%d decisions kept, expected %d", len(decisions), maxCashOutDecisions)
+	}
+	for i, decision := range decisions {
+		if decision.Seq != uint64(i+4) {
+			t.Fatalf("decision %d has sequence %d, expected %d", i, decision.Seq, i+4)
+		}
+	}
+
+	// the sequence resumes after a restart
+	a = &autoCashOut{stateStore: stateStore}
+	if err := a.loadSeq(); err != nil {
+		t.Fatal(err)
+	}
+	if a.seq != maxCashOutDecisions+3 {
+		t.Fatalf("sequence is %d, expected %d", a.seq, maxCashOutDecisions+3)
+	}
+}
@@ -0,0 +1,273 @@
+package mineservice
+
+import (
+	"encoding/json"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	blockIndexPrefix = "/mineblock/"
+	dagBlockPrefix   = "/minedag/"
+	dagRefPrefix     = "/minedagref/"
+)
+
+const (
+	BlockOriginPush      = "push"
+	BlockOriginMigration = "migration"
+	// BlockOriginDagMigration is a DAG migrated as a unit. A DAG pinned
+	// recursively is indexed under its root with the size of the DAG, the
+	// blocks of a DAG pinned directly are indexed one by one with their root.
+	BlockOriginDagMigration = "dag-migration"
+)
+
+// BlockRecord is a block stored for the queens. The source is the queen
+// which pushed the block, or the ant it was migrated from.
+type BlockRecord struct {
+	Cid       string
+	Size      uint64
+	Source    string
+	Origin    string
+	ArrivedAt int64
+	Root      string `json:",omitempty"` // root of the DAG pinned directly the block belongs to
+}
+
+type BlockTotal struct {
+	Blocks uint64
+	Bytes  uint64
+}
+
+type BlockDayTotal struct {
+	Day string
+	BlockTotal
+}
+
+// BlockSummary totals the stored blocks by origin and by day of arrival.
+type BlockSummary struct {
+	Total    BlockTotal
+	ByOrigin map[string]*BlockTotal
+	ByDay    []*BlockDayTotal
+}
+
+func BlockIndexKey(bcid cid.Cid) datastore.Key {
+	return datastore.NewKey(blockIndexPrefix + bcid.String())
+}
+
+func DagBlockKey(root, bcid cid.Cid) datastore.Key {
+	return datastore.NewKey(dagBlockPrefix + root.String() + "/" + bcid.String())
+}
+
+// DagRefKey is the reverse of DagBlockKey, it lists the roots of the DAGs
+// pinned directly a block belongs to.
+func DagRefKey(bcid, root cid.Cid) datastore.Key {
+	return datastore.NewKey(dagRefPrefix + bcid.String() + "/" + root.String())
+}
+
+// BlockIndex keeps the blocks stored for the queens in the state store, as
+// they are indistinguishable from other direct pins in the pinner.
+type BlockIndex struct {
+	stateStore statestore.StateStore
+}
+
+func NewBlockIndex(stateStore statestore.StateStore) *BlockIndex {
+	return &BlockIndex{
+		stateStore: stateStore,
+	}
+}
+
+// Add indexes the block, a block which is already indexed keeps its record.
+func (i *BlockIndex) Add(bcid cid.Cid, size uint64, source, origin string) error {
+	_, err := i.Get(bcid)
+	if err == nil {
+		return nil
+	}
+	if err != datastore.ErrNotFound {
+		return err
+	}
+	return i.stateStore.Put(BlockIndexKey(bcid), &BlockRecord{
+		Cid:       bcid.String(),
+		Size:      size,
+		Source:    source,
+		Origin:    origin,
+		ArrivedAt: time.Now().Unix(),
+	})
+}
+
+// AddDagBlock indexes a block of the DAG of the root which is pinned
+// directly. A block shared by several DAGs keeps the record of the first
+// one and is released with the last of their roots.
+func (i *BlockIndex) AddDagBlock(bcid cid.Cid, size uint64, source string, root cid.Cid) error {
+	if err := i.stateStore.Put(DagBlockKey(root, bcid), bcid.String()); err != nil {
+		return err
+	}
+	if err := i.stateStore.Put(DagRefKey(bcid, root), root.String()); err != nil {
+		return err
+	}
+	_, err := i.Get(bcid)
+	if err == nil {
+		return nil
+	}
+	if err != datastore.ErrNotFound {
+		return err
+	}
+	return i.stateStore.Put(BlockIndexKey(bcid), &BlockRecord{
+		Cid:       bcid.String(),
+		Size:      size,
+		Source:    source,
+		Origin:    BlockOriginDagMigration,
+		ArrivedAt: time.Now().Unix(),
+		Root:      root.String(),
+	})
+}
+
+// DagBlocks returns the blocks of the DAG of the root which is pinned
+// directly.
+func (i *BlockIndex) DagBlocks(root cid.Cid) ([]cid.Cid, error) {
+	var list []cid.Cid
+	err := i.stateStore.Iterate(dagBlockPrefix+root.String()+"/", func(key string, value []byte) (stop bool, err error) {
+		bcid, err := cid.Decode(key[strings.LastIndex(key, "/")+1:])
+		if err != nil {
+			log.Errorf("invalid dag block key %v: %v", key, err)
+			return false, nil
+		}
+		list = append(list, bcid)
+		return false, nil
+	})
+	return list, err
+}
+
+// DagRoots returns the roots of the DAGs pinned directly the block belongs
+// to.
+func (i *BlockIndex) DagRoots(bcid cid.Cid) ([]cid.Cid, error) {
+	var list []cid.Cid
+	err := i.stateStore.Iterate(dagRefPrefix+bcid.String()+"/", func(key string, value []byte) (stop bool, err error) {
+		root, err := cid.Decode(key[strings.LastIndex(key, "/")+1:])
+		if err != nil {
+			log.Errorf("invalid dag ref key %v: %v", key, err)
+			return false, nil
+		}
+		list = append(list, root)
+		return false, nil
+	})
+	return list, err
+}
+
+// RemoveDagBlock removes the block from the DAG of the root.
+func (i *BlockIndex) RemoveDagBlock(root, bcid cid.Cid) error {
+	if err := i.stateStore.Delete(DagRefKey(bcid, root)); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	return i.stateStore.Delete(DagBlockKey(root, bcid))
+}
+
+// SetRoot changes the DAG the record of the block refers to.
+func (i *BlockIndex) SetRoot(bcid cid.Cid, root cid.Cid) error {
+	record, err := i.Get(bcid)
+	if err != nil {
+		return err
+	}
+	record.Root = root.String()
+	return i.stateStore.Put(BlockIndexKey(bcid), record)
+}
+
+func (i *BlockIndex) Get(bcid cid.Cid) (*BlockRecord, error) {
+	record := &BlockRecord{}
+	err := i.stateStore.Get(BlockIndexKey(bcid), record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Has returns the record of the block and whether it is indexed.
+func (i *BlockIndex) Has(bcid cid.Cid) (*BlockRecord, bool, error) {
+	record, err := i.Get(bcid)
+	if err == datastore.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+func (i *BlockIndex) Remove(bcid cid.Cid) error {
+	return i.stateStore.Delete(BlockIndexKey(bcid))
+}
+
+// List returns the indexed blocks ordered by arrival.
+func (i *BlockIndex) List() ([]*BlockRecord, error) {
+	var list []*BlockRecord
+	err := i.stateStore.Iterate(blockIndexPrefix, func(key string, value []byte) (stop bool, err error) {
+		record := BlockRecord{}
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &record)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].ArrivedAt < list[j].ArrivedAt
+	})
+	return list, nil
+}
+
+// Cids returns the cids of the indexed blocks in order.
+func (i *BlockIndex) Cids() ([]string, error) {
+	var cids []string
+	err := i.stateStore.Iterate(blockIndexPrefix, func(key string, value []byte) (stop bool, err error) {
+		cids = append(cids, strings.TrimPrefix(key, blockIndexPrefix))
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(cids)
+	return cids, nil
+}
+
+// Summary totals the indexed blocks by origin and by day of arrival.
+func (i *BlockIndex) Summary() (*BlockSummary, error) {
+	list, err := i.List()
+	if err != nil {
+		return nil, err
+	}
+	summary := &BlockSummary{
+		ByOrigin: make(map[string]*BlockTotal),
+	}
+	days := make(map[string]*BlockDayTotal)
+	for _, record := range list {
+		summary.Total.add(record.Size)
+
+		origin, ok := summary.ByOrigin[record.Origin]
+		if !ok {
+			origin = &BlockTotal{}
+			summary.ByOrigin[record.Origin] = origin
+		}
+		origin.add(record.Size)
+
+		day := time.Unix(record.ArrivedAt, 0).UTC().Format("2006-01-02")
+		dayTotal, ok := days[day]
+		if !ok {
+			dayTotal = &BlockDayTotal{Day: day}
+			days[day] = dayTotal
+			summary.ByDay = append(summary.ByDay, dayTotal)
+		}
+		dayTotal.add(record.Size)
+	}
+	return summary, nil
+}
+
+func (t *BlockTotal) add(size uint64) {
+	t.Blocks++
+	t.Bytes += size
+}
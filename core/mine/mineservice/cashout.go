@@ -0,0 +1,194 @@
+package mineservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	cashOutPrefix = "/cashout/tx/"
+)
+
+const (
+	CashOutStatusPending  = "pending"
+	CashOutStatusMined    = "mined"
+	CashOutStatusReverted = "reverted"
+	CashOutStatusDropped  = "dropped"
+)
+
+// CashOutRecord tracks a cash-out transaction until its final outcome.
+type CashOutRecord struct {
+	TxHash           string
+	Chequebook       string
+	Amount           string
+	CumulativePayout string
+	Status           string
+	GasUsed          uint64
+	BlockNumber      uint64
+	RevertReason     string
+	Created          int64
+	Updated          int64
+}
+
+func CashOutKey(txHash common.Hash) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%x", cashOutPrefix, txHash))
+}
+
+func (c *ChequeStore) SaveCashOut(record *CashOutRecord) error {
+	return c.stateStore.Put(CashOutKey(common.HexToHash(record.TxHash)), record)
+}
+
+func (c *ChequeStore) GetCashOut(txHash common.Hash) (*CashOutRecord, error) {
+	record := &CashOutRecord{}
+	err := c.stateStore.Get(CashOutKey(txHash), record)
+	return record, err
+}
+
+// GetCashOuts returns all cash-out records, newest first.
+func (c *ChequeStore) GetCashOuts() ([]*CashOutRecord, error) {
+	var list []*CashOutRecord
+	err := c.stateStore.Iterate(cashOutPrefix, func(key string, value []byte) (stop bool, err error) {
+		record := CashOutRecord{}
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &record)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created > list[j].Created
+	})
+	return list, nil
+}
+
+// GetCashOuts returns all recorded cash-outs, newest first.
+func (m *ChequeManager) GetCashOuts(ctx context.Context) ([]*CashOutRecord, error) {
+	return m.chequeStore.GetCashOuts()
+}
+
+// WaitCashOut waits until the cash-out transaction reached its final status
+// or the context is cancelled, and returns its record.
+func (m *ChequeManager) WaitCashOut(ctx context.Context, txHash common.Hash) (*CashOutRecord, error) {
+	record, err := m.chequeStore.GetCashOut(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != CashOutStatusPending {
+		return record, nil
+	}
+	return m.reconcileCashOut(ctx, record)
+}
+
+// trackCashOut records a submitted cash-out and reconciles it in the background.
+func (m *ChequeManager) trackCashOut(txHash common.Hash, chequebook string, amount, cumulativePayout *big.Int) {
+	now := time.Now().Unix()
+	record := &CashOutRecord{
+		TxHash:           txHash.Hex(),
+		Chequebook:       chequebook,
+		Amount:           amount.String(),
+		CumulativePayout: cumulativePayout.String(),
+		Status:           CashOutStatusPending,
+		Created:          now,
+		Updated:          now,
+	}
+	if err := m.chequeStore.SaveCashOut(record); err != nil {
+		log.Errorf("failed to save cash out %v: %v", record.TxHash, err)
+	}
+	submitted := *record
+	m.events.Publish(EventCashOutSubmitted, &submitted)
+	m.waitForCashOut(record)
+}
+
+// resumeCashOuts reconciles the cash-outs which were still pending when the
+// node was stopped.
+func (m *ChequeManager) resumeCashOuts() {
+	records, err := m.chequeStore.GetCashOuts()
+	if err != nil {
+		log.Errorf("failed to get cash outs: %v", err)
+		return
+	}
+	for _, record := range records {
+		if record.Status == CashOutStatusPending {
+			m.waitForCashOut(record)
+		}
+	}
+}
+
+func (m *ChequeManager) waitForCashOut(record *CashOutRecord) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		final, err := m.reconcileCashOut(m.ctx, record)
+		if err != nil {
+			log.Errorf("error while waiting for cash out %v: %v", record.TxHash, err)
+			return
+		}
+		m.events.Publish(EventCashOutFinished, final)
+	}()
+}
+
+func (m *ChequeManager) reconcileCashOut(ctx context.Context, record *CashOutRecord) (*CashOutRecord, error) {
+	txHash := common.HexToHash(record.TxHash)
+	receipt, err := m.transactionService.WaitForReceipt(ctx, txHash)
+	if err != nil {
+		if !errors.Is(err, transaction.ErrTransactionCancelled) {
+			return nil, err
+		}
+		record.Status = CashOutStatusDropped
+	} else {
+		record.GasUsed = receipt.GasUsed
+		record.BlockNumber = receipt.BlockNumber.Uint64()
+		if receipt.Status == types.ReceiptStatusSuccessful {
+			record.Status = CashOutStatusMined
+		} else {
+			record.Status = CashOutStatusReverted
+			record.RevertReason = m.revertReason(ctx, txHash, receipt.BlockNumber)
+		}
+	}
+	record.Updated = time.Now().Unix()
+	log.Infof("cash out %v of %v: %v", record.TxHash, record.Chequebook, record.Status)
+	if err := m.chequeStore.SaveCashOut(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// revertReason replays the reverted transaction at the block it was mined in
+// to get the error returned by the contract.
+func (m *ChequeManager) revertReason(ctx context.Context, txHash common.Hash, blockNumber *big.Int) string {
+	tx, _, err := m.backend.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return fmt.Sprintf("unknown (failed to get transaction: %v)", err)
+	}
+	from, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return fmt.Sprintf("unknown (failed to get sender: %v)", err)
+	}
+	_, err = m.backend.CallContract(ctx, ethereum.CallMsg{
+		From:     from,
+		To:       tx.To(),
+		Gas:      tx.Gas(),
+		GasPrice: tx.GasPrice(),
+		Value:    tx.Value(),
+		Data:     tx.Data(),
+	}, blockNumber)
+	if err != nil {
+		return err.Error()
+	}
+	return "unknown"
+}
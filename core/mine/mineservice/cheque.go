@@ -3,6 +3,7 @@ package mineservice
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ipfs/go-ipfs/core/mine/contracts/chequebook"
 	"github.com/ipfs/go-ipfs/core/mine/transaction"
@@ -10,18 +11,57 @@ import (
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	ant_pro "github.com/antnest-network/ant-proto/pb"
 	"math/big"
+	"sync"
 )
 
+var ErrNothingToCashOut = errors.New("uncashed out amount is zero")
+
+const (
+	CashOutResultSubmitted = "submitted"
+	CashOutResultSkipped   = "skipped"
+	CashOutResultFailed    = "failed"
+)
+
+// CashOutResult is the outcome of cashing out one chequebook in a batch.
+type CashOutResult struct {
+	Chequebook string
+	Status     string
+	Amount     string
+	TxHash     string
+	Reason     string
+}
+
 type ChequeManager struct {
 	chequeStore        *ChequeStore
+	backend            transaction.Backend
 	transactionService transaction.Service
+	cashOutLock        sync.Mutex
+	events             *EventBus
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewChequeManager(chequeStore *ChequeStore, transactionService transaction.Service) *ChequeManager {
-	return &ChequeManager{
+func NewChequeManager(chequeStore *ChequeStore, backend transaction.Backend, transactionService transaction.Service,
+	events *EventBus) *ChequeManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &ChequeManager{
 		chequeStore:        chequeStore,
+		backend:            backend,
 		transactionService: transactionService,
+		events:             events,
+		ctx:                ctx,
+		cancel:             cancel,
 	}
+	m.resumeCashOuts()
+	return m
+}
+
+func (m *ChequeManager) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	return nil
 }
 
 func (m *ChequeManager) GetCheque(ctx context.Context, chequebookContract string) (iface.Cheque, error) {
@@ -51,51 +91,130 @@ func (m *ChequeManager) GetCheques(ctx context.Context) ([]iface.Cheque, error)
 	return cheques, nil
 }
 
+// GetHistory returns every accepted cheque of the chequebook, or of all
+// chequebooks if chequebook is empty.
+func (m *ChequeManager) GetHistory(ctx context.Context, chequebook string) ([]*ChequeRecord, error) {
+	return m.chequeStore.GetHistory(chequebook)
+}
+
 func (m *ChequeManager) CashOut(ctx context.Context, chequebookContract string) error {
+	_, err := m.CashOutCheque(ctx, chequebookContract)
+	return err
+}
+
+// CashOutCheque cashes out the uncashed amount of the chequebook and returns
+// the hash of the cash-out transaction.
+func (m *ChequeManager) CashOutCheque(ctx context.Context, chequebookContract string) (common.Hash, error) {
 	cheque, err := m.chequeStore.GetCheque(chequebookContract)
 	if err != nil {
 		log.Errorf("failed to get cheque: %v", err)
-		return err
+		return common.Hash{}, err
 	}
-	ch, err := m.convertCheque(ctx, cheque)
+
+	m.cashOutLock.Lock()
+	defer m.cashOutLock.Unlock()
+
+	uncashed, err := m.Uncashed(ctx, cheque)
 	if err != nil {
-		return err
+		return common.Hash{}, err
 	}
-	if ch.CanCashOut == "0" || ch.CanCashOut == "" {
-		return errors.New("uncashed out amount is zero")
+	if uncashed.Sign() <= 0 {
+		return common.Hash{}, ErrNothingToCashOut
 	}
+	return m.cashOut(ctx, cheque, uncashed)
+}
+
+// cashOut sends the cash-out transaction of the cheque. It must be called
+// with cashOutLock held.
+func (m *ChequeManager) cashOut(ctx context.Context, cheque *ant_pro.Cheque, uncashed *big.Int) (common.Hash, error) {
 	contract := chequebook.NewChequebookContract(m.transactionService)
 	cumulativePayout, _ := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
-	_, err = contract.CashCheque(ctx, common.HexToAddress(cheque.Chequebook), common.HexToAddress(cheque.Beneficiary), cumulativePayout, cheque.Signature)
+	txHash, err := contract.CashCheque(ctx, common.HexToAddress(cheque.Chequebook), common.HexToAddress(cheque.Beneficiary), cumulativePayout, cheque.Signature)
 	if err != nil {
 		log.Errorf("failed to get cash out cheque: %v", err)
-		return err
+		return common.Hash{}, err
 	}
-	return err
+	log.Infof("cash out cheque of %v, amount: %v, tx: %v", cheque.Chequebook, uncashed, txHash.Hex())
+	m.trackCashOut(txHash, cheque.Chequebook, uncashed, cumulativePayout)
+	return txHash, nil
+}
+
+// Uncashed returns the part of the cumulative payout of the cheque that was
+// not cashed out yet.
+func (m *ChequeManager) Uncashed(ctx context.Context, cheque *ant_pro.Cheque) (*big.Int, error) {
+	paidout, err := chequebook.NewChequebookContract(m.transactionService).PaidOut(ctx,
+		common.HexToAddress(cheque.Chequebook), common.HexToAddress(cheque.Beneficiary))
+	if err != nil {
+		log.Errorf("failed to get PaidOut: %v", err)
+		return nil, err
+	}
+	cumulativePayout, ok := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
+	if !ok {
+		return nil, ErrInvalidPayout
+	}
+	return big.NewInt(0).Sub(cumulativePayout, paidout), nil
 }
 
 func (m *ChequeManager) CashOutAll(ctx context.Context) error {
-	list, err := m.chequeStore.GetCheques()
+	results, err := m.CashOutAllResults(ctx)
 	if err != nil {
 		return err
 	}
+	failed := 0
+	for _, result := range results {
+		if result.Status == CashOutResultFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cash outs failed", failed, len(results))
+	}
+	return nil
+}
+
+// CashOutAllResults tries to cash out every chequebook with an uncashed
+// amount and returns the result for each chequebook. A failing chequebook
+// does not stop the others from being cashed out.
+func (m *ChequeManager) CashOutAllResults(ctx context.Context) ([]*CashOutResult, error) {
+	list, err := m.chequeStore.GetCheques()
+	if err != nil {
+		return nil, err
+	}
+
+	// hold the lock for the whole batch so that the transactions of the batch
+	// get consecutive nonces and do not interleave with other cash-outs
+	m.cashOutLock.Lock()
+	defer m.cashOutLock.Unlock()
+
+	results := make([]*CashOutResult, 0, len(list))
 	for _, cheque := range list {
-		contract := chequebook.NewChequebookContract(m.transactionService)
-		ch, err := m.convertCheque(ctx, cheque)
+		result := &CashOutResult{
+			Chequebook: cheque.Chequebook,
+		}
+		results = append(results, result)
+
+		uncashed, err := m.Uncashed(ctx, cheque)
 		if err != nil {
+			result.Status = CashOutResultFailed
+			result.Reason = err.Error()
 			continue
 		}
-		if ch.CanCashOut == "0" || ch.CashedOut == "" {
+		result.Amount = uncashed.String()
+		if uncashed.Sign() <= 0 {
+			result.Status = CashOutResultSkipped
+			result.Reason = ErrNothingToCashOut.Error()
 			continue
 		}
-		cumulativePayout, _ := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
-		_, err = contract.CashCheque(ctx, common.HexToAddress(cheque.Chequebook), common.HexToAddress(cheque.Beneficiary), cumulativePayout, cheque.Signature)
+		txHash, err := m.cashOut(ctx, cheque, uncashed)
 		if err != nil {
-			log.Errorf("failed to get cash out cheque: %v", err)
-			return err
+			result.Status = CashOutResultFailed
+			result.Reason = err.Error()
+			continue
 		}
+		result.Status = CashOutResultSubmitted
+		result.TxHash = txHash.Hex()
 	}
-	return nil
+	return results, nil
 }
 
 func (m *ChequeManager) convertCheque(ctx context.Context, cheque *ant_pro.Cheque) (iface.Cheque, error) {
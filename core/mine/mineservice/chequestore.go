@@ -2,38 +2,170 @@ package mineservice
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/libp2p/go-libp2p-core/peer"
 	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	chequePrefix = "/cheque/"
+	chequePrefix        = "/cheque/"
+	chequeHistoryPrefix = "/chequehistory/"
+	heldChequePrefix    = "/chequeheld/"
 )
 
+var (
+	ErrChequeNotIncreasing = errors.New("cumulative payout is not above the current cheque")
+)
+
+// normalizeChequebook returns the checksummed form of the chequebook
+// address, so that the cheques of a chequebook share their keys whatever the
+// case of the address they carry.
+func normalizeChequebook(chequebook string) string {
+	return common.HexToAddress(chequebook).Hex()
+}
+
 func ChequeKey(chequebook string) datastore.Key {
-	return datastore.NewKey(chequePrefix + chequebook)
+	return datastore.NewKey(chequePrefix + normalizeChequebook(chequebook))
+}
+
+func ChequeHistoryKey(chequebook string, receivedAt int64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%s/%020d", chequeHistoryPrefix, normalizeChequebook(chequebook), receivedAt))
+}
+
+// HeldChequeKey is the key of the cheque of the chequebook received while
+// the chain could not be reached to validate it.
+func HeldChequeKey(chequebook string) datastore.Key {
+	return datastore.NewKey(heldChequePrefix + normalizeChequebook(chequebook))
+}
+
+// ChequeRecord is an accepted cheque in the history of a chequebook.
+type ChequeRecord struct {
+	Cheque     *ant_pro.Cheque
+	From       string
+	ReceivedAt int64
+}
+
+// HeldCheque is a cheque waiting for the chain to be validated.
+type HeldCheque struct {
+	Cheque *ant_pro.Cheque
+	From   string
+	HeldAt int64
 }
 
 type ChequeStore struct {
 	stateStore statestore.StateStore
+	lock       sync.Mutex
 }
 
-func NewChequeStore(stateStore statestore.StateStore) *ChequeStore {
-	return &ChequeStore{
+func NewChequeStore(stateStore statestore.StateStore) (*ChequeStore, error) {
+	c := &ChequeStore{
 		stateStore: stateStore,
 	}
+	if err := c.migrateLegacyKeys(); err != nil {
+		return nil, fmt.Errorf("failed to migrate cheque keys: %w", err)
+	}
+	return c, nil
+}
+
+// migrateLegacyKeys moves the cheques saved before the addresses were
+// normalized under the normalized keys. The cheque of the highest cumulative
+// payout is kept when a chequebook was saved under several cases.
+func (c *ChequeStore) migrateLegacyKeys() error {
+	var legacy []string
+	err := c.stateStore.Iterate(chequePrefix, func(key string, value []byte) (stop bool, err error) {
+		chequebook := strings.TrimPrefix(key, chequePrefix)
+		if common.IsHexAddress(chequebook) && chequebook != normalizeChequebook(chequebook) {
+			legacy = append(legacy, chequebook)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, chequebook := range legacy {
+		key := datastore.NewKey(chequePrefix + chequebook)
+		cheque := &ant_pro.Cheque{}
+		if err := c.stateStore.Get(key, cheque); err != nil {
+			return err
+		}
+		current, err := c.GetCheque(chequebook)
+		if err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+		if err == datastore.ErrNotFound || payoutBelow(current, cheque) {
+			if err := c.SaveCheque(cheque); err != nil {
+				return err
+			}
+		}
+		if err := c.stateStore.Delete(key); err != nil {
+			return err
+		}
+		log.Infof("migrated cheque of chequebook %v", chequebook)
+	}
+	return nil
+}
+
+// payoutBelow reports whether the cumulative payout of a is below the one
+// of b.
+func payoutBelow(a, b *ant_pro.Cheque) bool {
+	payoutA, okA := big.NewInt(0).SetString(a.CumulativePayout, 10)
+	payoutB, okB := big.NewInt(0).SetString(b.CumulativePayout, 10)
+	if !okA || !okB {
+		return !okA && okB
+	}
+	return payoutA.Cmp(payoutB) < 0
 }
 
 func (c *ChequeStore) SaveCheque(cheque *ant_pro.Cheque) error {
-	key := ChequeKey(cheque.Chequebook)
-	return c.stateStore.Put(key, cheque)
+	return c.stateStore.Put(ChequeKey(cheque.Chequebook), cheque)
+}
+
+// ReceiveCheque stores the cheque if its cumulative payout is above the one
+// of the cheque we already hold for the chequebook, and appends it to the
+// history of the chequebook.
+func (c *ChequeStore) ReceiveCheque(cheque *ant_pro.Cheque, from peer.ID) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cumulativePayout, ok := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
+	if !ok {
+		return ErrInvalidPayout
+	}
+	current, err := c.GetCheque(cheque.Chequebook)
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		currentPayout, ok := big.NewInt(0).SetString(current.CumulativePayout, 10)
+		if ok && cumulativePayout.Cmp(currentPayout) <= 0 {
+			return ErrChequeNotIncreasing
+		}
+	}
+
+	receivedAt := time.Now().UnixNano()
+	err = c.stateStore.Put(ChequeHistoryKey(cheque.Chequebook, receivedAt), &ChequeRecord{
+		Cheque:     cheque,
+		From:       from.String(),
+		ReceivedAt: receivedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return c.SaveCheque(cheque)
 }
 
 func (c *ChequeStore) GetCheque(chequebook string) (*ant_pro.Cheque, error) {
-	key := ChequeKey(chequebook)
 	val := &ant_pro.Cheque{}
-	err := c.stateStore.Get(key, val)
+	err := c.stateStore.Get(ChequeKey(chequebook), val)
 	return val, err
 }
 
@@ -52,3 +184,101 @@ func (c *ChequeStore) GetCheques() ([]*ant_pro.Cheque, error) {
 	//log.Infof("Cheques: %v", list)
 	return list, nil
 }
+
+// GetHistory returns the accepted cheques of the chequebook ordered by the
+// time they were received. An empty chequebook returns the history of all
+// chequebooks.
+func (c *ChequeStore) GetHistory(chequebook string) ([]*ChequeRecord, error) {
+	prefix := chequeHistoryPrefix
+	if chequebook != "" {
+		prefix = chequeHistoryPrefix + normalizeChequebook(chequebook) + "/"
+	}
+	var list []*ChequeRecord
+	err := c.stateStore.Iterate(prefix, func(key string, value []byte) (stop bool, err error) {
+		record := ChequeRecord{}
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &record)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ReceivedAt < list[j].ReceivedAt
+	})
+	return list, nil
+}
+
+// HoldCheque keeps the cheque until it can be validated. Only the cheque of
+// the highest cumulative payout is kept for a chequebook, the cheques below
+// it would not be accepted anyway.
+func (c *ChequeStore) HoldCheque(cheque *ant_pro.Cheque, from peer.ID) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cumulativePayout, ok := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
+	if !ok {
+		return ErrInvalidPayout
+	}
+	held := HeldCheque{}
+	err := c.stateStore.Get(HeldChequeKey(cheque.Chequebook), &held)
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		heldPayout, ok := big.NewInt(0).SetString(held.Cheque.CumulativePayout, 10)
+		if ok && cumulativePayout.Cmp(heldPayout) <= 0 {
+			return nil
+		}
+	}
+	return c.stateStore.Put(HeldChequeKey(cheque.Chequebook), &HeldCheque{
+		Cheque: cheque,
+		From:   from.String(),
+		HeldAt: time.Now().UnixNano(),
+	})
+}
+
+// HeldCheques returns the cheques waiting for the chain.
+func (c *ChequeStore) HeldCheques() ([]*HeldCheque, error) {
+	var list []*HeldCheque
+	err := c.stateStore.Iterate(heldChequePrefix, func(key string, value []byte) (stop bool, err error) {
+		held := HeldCheque{}
+		if err := json.Unmarshal(value, &held); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &held)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].HeldAt < list[j].HeldAt
+	})
+	return list, nil
+}
+
+// ReleaseHeldCheque removes the held cheque once validated, unless a cheque
+// of the chequebook was held again since.
+func (c *ChequeStore) ReleaseHeldCheque(held *HeldCheque) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	current := HeldCheque{}
+	err := c.stateStore.Get(HeldChequeKey(held.Cheque.Chequebook), &current)
+	if err == datastore.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.HeldAt != held.HeldAt {
+		return nil
+	}
+	return c.stateStore.Delete(HeldChequeKey(held.Cheque.Chequebook))
+}
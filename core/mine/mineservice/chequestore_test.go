@@ -0,0 +1,167 @@
+package mineservice
+
+import (
+	"strings"
+	"testing"
+
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const testChequebook = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+func newTestChequeStore(t *testing.T) (*ChequeStore, statestore.StateStore) {
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	store, err := NewChequeStore(stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, stateStore
+}
+
+func TestChequeKeyNormalized(t *testing.T) {
+	for _, chequebook := range []string{
+		strings.ToLower(testChequebook),
+		strings.ToUpper(testChequebook[2:]),
+		"0X" + testChequebook[2:],
+	} {
+		if key := ChequeKey(chequebook); key != ChequeKey(testChequebook) {
+			t.Errorf("key of %v is %v, expected %v", chequebook, key, ChequeKey(testChequebook))
+		}
+		if key := ChequeHistoryKey(chequebook, 1); key != ChequeHistoryKey(testChequebook, 1) {
+			t.Errorf("history key of %v is %v, expected %v", chequebook, key, ChequeHistoryKey(testChequebook, 1))
+		}
+	}
+}
+
+func TestReceiveChequeNormalized(t *testing.T) {
+	store, _ := newTestChequeStore(t)
+	from := peer.ID("queen")
+
+	err := store.ReceiveCheque(&ant_pro.Cheque{
+		Chequebook:       strings.ToLower(testChequebook),
+		CumulativePayout: "100",
+	}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ReceiveCheque(&ant_pro.Cheque{
+		Chequebook:       testChequebook,
+		CumulativePayout: "100",
+	}, from)
+	if err != ErrChequeNotIncreasing {
+		t.Fatalf("got %v for a cheque of the same payout, expected %v", err, ErrChequeNotIncreasing)
+	}
+	err = store.ReceiveCheque(&ant_pro.Cheque{
+		Chequebook:       strings.ToUpper(testChequebook[2:]),
+		CumulativePayout: "150",
+	}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheques, err := store.GetCheques()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cheques) != 1 || cheques[0].CumulativePayout != "150" {
+		t.Fatalf("unexpected cheques %v", cheques)
+	}
+	history, err := store.GetHistory(strings.ToLower(testChequebook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("%d cheques in the history, expected 2", len(history))
+	}
+}
+
+func TestMigrateLegacyChequeKeys(t *testing.T) {
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	for chequebook, payout := range map[string]string{
+		strings.ToLower(testChequebook):            "100",
+		"0x" + strings.ToUpper(testChequebook[2:]): "200",
+		"not an address":                           "300",
+	} {
+		err := stateStore.Put(datastore.NewKey(chequePrefix+chequebook), &ant_pro.Cheque{
+			Chequebook:       chequebook,
+			CumulativePayout: payout,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := NewChequeStore(stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chequebook := range []string{strings.ToLower(testChequebook), "0x" + strings.ToUpper(testChequebook[2:])} {
+		err := stateStore.Get(datastore.NewKey(chequePrefix+chequebook), &ant_pro.Cheque{})
+		if err != datastore.ErrNotFound {
+			t.Fatalf("legacy cheque %v was not removed: %v", chequebook, err)
+		}
+	}
+	saved, err := store.GetCheque(strings.ToLower(testChequebook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.CumulativePayout != "200" {
+		t.Fatalf("cumulative payout is %v, expected 200", saved.CumulativePayout)
+	}
+	cheque := &ant_pro.Cheque{Chequebook: testChequebook, CumulativePayout: "150"}
+	if err := store.ReceiveCheque(cheque, peer.ID("queen")); err != ErrChequeNotIncreasing {
+		t.Fatalf("got %v for a cheque below the legacy one, expected %v", err, ErrChequeNotIncreasing)
+	}
+	cheques, err := store.GetCheques()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cheques) != 2 {
+		t.Fatalf("%d cheques, expected the migrated one and the invalid one", len(cheques))
+	}
+}
+
+func TestHoldCheque(t *testing.T) {
+	store, _ := newTestChequeStore(t)
+	from := peer.ID("queen")
+
+	for _, payout := range []string{"100", "50", "150"} {
+		cheque := &ant_pro.Cheque{Chequebook: testChequebook, CumulativePayout: payout}
+		if err := store.HoldCheque(cheque, from); err != nil {
+			t.Fatal(err)
+		}
+	}
+	held, err := store.HeldCheques()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(held) != 1 || held[0].Cheque.CumulativePayout != "150" {
+		t.Fatalf("unexpected held cheques %+v", held)
+	}
+
+	// a cheque held again after the listing stays held
+	newer := &ant_pro.Cheque{Chequebook: strings.ToLower(testChequebook), CumulativePayout: "200"}
+	if err := store.HoldCheque(newer, from); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ReleaseHeldCheque(held[0]); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.HeldCheques()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Cheque.CumulativePayout != "200" {
+		t.Fatalf("unexpected held cheques %+v", list)
+	}
+	if err := store.ReleaseHeldCheque(list[0]); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := store.HeldCheques(); len(list) != 0 {
+		t.Fatalf("%d cheques still held", len(list))
+	}
+}
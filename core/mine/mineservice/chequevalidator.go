@@ -0,0 +1,136 @@
+package mineservice
+
+import (
+	"context"
+	"errors"
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/contracts/chequebook"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+	"math/big"
+	"sync"
+)
+
+var (
+	ErrInvalidChequebook   = errors.New("invalid chequebook address")
+	ErrInvalidBeneficiary  = errors.New("invalid beneficiary address")
+	ErrInvalidPayout       = errors.New("invalid cumulative payout")
+	ErrInvalidSignature    = errors.New("invalid cheque signature")
+	ErrIssuerMismatch      = errors.New("cheque not signed by chequebook issuer")
+	ErrIssuerUnavailable   = errors.New("failed to get chequebook issuer")
+	ErrBeneficiaryMismatch = errors.New("cheque beneficiary is not our wallet")
+)
+
+// ChequeValidator checks incoming cheques against the chequebook contract
+// before they are accepted into the cheque store.
+type ChequeValidator struct {
+	transactionService transaction.Service
+	signer             crypto.Signer
+	chainID            func() (*big.Int, error)
+
+	lock     sync.Mutex
+	issuers  map[common.Address]common.Address // chequebook -> issuer, the issuer of a chequebook never changes
+	rejected map[string]uint64                 // rejection reason -> count
+}
+
+func NewChequeValidator(transactionService transaction.Service, signer crypto.Signer, chainID func() (*big.Int, error)) *ChequeValidator {
+	return &ChequeValidator{
+		transactionService: transactionService,
+		signer:             signer,
+		chainID:            chainID,
+		issuers:            make(map[common.Address]common.Address),
+		rejected:           make(map[string]uint64),
+	}
+}
+
+// Validate rebuilds the typed data of the cheque and checks that it was signed
+// by the issuer of the chequebook and that it is payable to our wallet. It
+// fails with chain.ErrChainUnavailable while the chain cannot be reached.
+func (v *ChequeValidator) Validate(ctx context.Context, cheque *ant_pro.Cheque) error {
+	if !common.IsHexAddress(cheque.Chequebook) {
+		return ErrInvalidChequebook
+	}
+	if !common.IsHexAddress(cheque.Beneficiary) {
+		return ErrInvalidBeneficiary
+	}
+	cumulativePayout, ok := big.NewInt(0).SetString(cheque.CumulativePayout, 10)
+	if !ok || cumulativePayout.Sign() < 0 {
+		return ErrInvalidPayout
+	}
+
+	beneficiary := common.HexToAddress(cheque.Beneficiary)
+	ethAddress, err := v.signer.EthereumAddress()
+	if err != nil {
+		return err
+	}
+	if beneficiary != ethAddress {
+		return ErrBeneficiaryMismatch
+	}
+
+	chainID, err := v.chainID()
+	if err != nil {
+		return chain.ErrChainUnavailable
+	}
+	chequebookAddress := common.HexToAddress(cheque.Chequebook)
+	signer, err := chequebook.RecoverCheque(&chequebook.Cheque{
+		Chequebook:       chequebookAddress,
+		Beneficiary:      beneficiary,
+		CumulativePayout: cumulativePayout,
+	}, cheque.Signature, chainID)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	issuer, err := v.issuer(ctx, chequebookAddress)
+	if err != nil {
+		log.Errorf("failed to get issuer of %v: %v", chequebookAddress.Hex(), err)
+		if errors.Is(err, chain.ErrChainUnavailable) {
+			return chain.ErrChainUnavailable
+		}
+		return ErrIssuerUnavailable
+	}
+	if signer != issuer {
+		return ErrIssuerMismatch
+	}
+	return nil
+}
+
+func (v *ChequeValidator) issuer(ctx context.Context, chequebookAddress common.Address) (common.Address, error) {
+	v.lock.Lock()
+	issuer, ok := v.issuers[chequebookAddress]
+	v.lock.Unlock()
+	if ok {
+		return issuer, nil
+	}
+
+	issuer, err := chequebook.NewChequebookContract(v.transactionService).Issuer(ctx, chequebookAddress)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	v.lock.Lock()
+	v.issuers[chequebookAddress] = issuer
+	v.lock.Unlock()
+	return issuer, nil
+}
+
+// Reject records a rejected cheque under the given reason.
+func (v *ChequeValidator) Reject(reason error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.rejected[reason.Error()]++
+	chequesReceived.WithLabelValues(chequeRejectReason(reason)).Inc()
+}
+
+// Rejected returns the number of rejected cheques by reason.
+func (v *ChequeValidator) Rejected() map[string]uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	ret := make(map[string]uint64, len(v.rejected))
+	for reason, count := range v.rejected {
+		ret[reason] = count
+	}
+	return ret
+}
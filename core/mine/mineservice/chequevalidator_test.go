@@ -0,0 +1,158 @@
+package mineservice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
+	"github.com/ipfs/go-ipfs/core/mine/contracts/chequebook"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/transaction"
+)
+
+// issuerService answers the issuer call of the chequebook contract.
+type issuerService struct {
+	transaction.Service
+	issuer common.Address
+	err    error
+}
+
+func (s *issuerService) Call(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return common.LeftPadBytes(s.issuer.Bytes(), 32), nil
+}
+
+func newTestSigner(t *testing.T) (crypto.Signer, common.Address) {
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, address
+}
+
+func signTestCheque(t *testing.T, signer crypto.Signer, beneficiary common.Address, payout int64, chainID *big.Int) *ant_pro.Cheque {
+	signature, err := signer.SignTypedData(chequebook.ChequeTypedData(&chequebook.Cheque{
+		Chequebook:       common.HexToAddress(testChequebook),
+		Beneficiary:      beneficiary,
+		CumulativePayout: big.NewInt(payout),
+	}, chainID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ant_pro.Cheque{
+		Chequebook:       testChequebook,
+		Beneficiary:      beneficiary.Hex(),
+		CumulativePayout: big.NewInt(payout).String(),
+		Signature:        signature,
+	}
+}
+
+func TestValidateCheque(t *testing.T) {
+	chainID := big.NewInt(56)
+	wallet, walletAddress := newTestSigner(t)
+	issuer, issuerAddress := newTestSigner(t)
+	other, otherAddress := newTestSigner(t)
+
+	for _, tc := range []struct {
+		name     string
+		cheque   func() *ant_pro.Cheque
+		service  *issuerService
+		chainErr error
+		err      error
+	}{{
+		name:   "valid",
+		cheque: func() *ant_pro.Cheque { return signTestCheque(t, issuer, walletAddress, 100, chainID) },
+	}, {
+		name: "invalid chequebook",
+		cheque: func() *ant_pro.Cheque {
+			c := signTestCheque(t, issuer, walletAddress, 100, chainID)
+			c.Chequebook = "chequebook"
+			return c
+		},
+		err: ErrInvalidChequebook,
+	}, {
+		name: "invalid beneficiary",
+		cheque: func() *ant_pro.Cheque {
+			c := signTestCheque(t, issuer, walletAddress, 100, chainID)
+			c.Beneficiary = "beneficiary"
+			return c
+		},
+		err: ErrInvalidBeneficiary,
+	}, {
+		name: "negative payout",
+		cheque: func() *ant_pro.Cheque {
+			c := signTestCheque(t, issuer, walletAddress, 100, chainID)
+			c.CumulativePayout = "-100"
+			return c
+		},
+		err: ErrInvalidPayout,
+	}, {
+		name:   "payable to another wallet",
+		cheque: func() *ant_pro.Cheque { return signTestCheque(t, issuer, otherAddress, 100, chainID) },
+		err:    ErrBeneficiaryMismatch,
+	}, {
+		name: "malformed signature",
+		cheque: func() *ant_pro.Cheque {
+			c := signTestCheque(t, issuer, walletAddress, 100, chainID)
+			c.Signature = []byte("signature")
+			return c
+		},
+		err: ErrInvalidSignature,
+	}, {
+		name:   "signed by another key",
+		cheque: func() *ant_pro.Cheque { return signTestCheque(t, other, walletAddress, 100, chainID) },
+		err:    ErrIssuerMismatch,
+	}, {
+		name: "payout raised after signing",
+		cheque: func() *ant_pro.Cheque {
+			c := signTestCheque(t, issuer, walletAddress, 100, chainID)
+			c.CumulativePayout = "1000"
+			return c
+		},
+		err: ErrIssuerMismatch,
+	}, {
+		name:   "signed for another chain",
+		cheque: func() *ant_pro.Cheque { return signTestCheque(t, issuer, walletAddress, 100, big.NewInt(1)) },
+		err:    ErrIssuerMismatch,
+	}, {
+		name:    "issuer call failing",
+		cheque:  func() *ant_pro.Cheque { return signTestCheque(t, issuer, walletAddress, 100, chainID) },
+		service: &issuerService{err: errors.New("execution reverted")},
+		err:     ErrIssuerUnavailable,
+	}, {
+		name:    "chain down on the issuer call",
+		cheque:  func() *ant_pro.Cheque { return signTestCheque(t, issuer, walletAddress, 100, chainID) },
+		service: &issuerService{err: chain.ErrChainUnavailable},
+		err:     chain.ErrChainUnavailable,
+	}, {
+		name:     "chain down on the chain id",
+		cheque:   func() *ant_pro.Cheque { return signTestCheque(t, issuer, walletAddress, 100, chainID) },
+		chainErr: errors.New("dial failed"),
+		err:      chain.ErrChainUnavailable,
+	}} {
+		service := tc.service
+		if service == nil {
+			service = &issuerService{issuer: issuerAddress}
+		}
+		v := NewChequeValidator(service, wallet, func() (*big.Int, error) {
+			if tc.chainErr != nil {
+				return nil, tc.chainErr
+			}
+			return chainID, nil
+		})
+		if err := v.Validate(context.Background(), tc.cheque()); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, expected %v", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package mineservice
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/go-ipfs/core/mine/migration"
+	"time"
+)
+
+// Config holds the settings of the mine service which live under the Ant
+// section of the node config.
+type Config struct {
+	CashOut       CashOutPolicy
+	Authorization Authorization
+	Roster        Roster
+	Storage       Storage
+	Migration     Migration
+	Scrub         Scrub
+	Alerts        Alerts
+	Webhook       Webhook
+}
+
+// Alerts configures the conditions checked periodically and published as
+// events (Ant.Alerts).
+type Alerts struct {
+	// CheckInterval is the time between two checks, e.g. "1m".
+	CheckInterval string
+	// MinBNBBalance is the BNB balance of the wallet under which the balance
+	// is reported low.
+	MinBNBBalance string
+	// QueenUnreachableAfter is how long a queen may not answer the pings
+	// before it is reported unreachable, e.g. "10m".
+	QueenUnreachableAfter string
+	// QuotaWarningFraction is the fraction of the storage quota above which
+	// the quota is reported nearly full.
+	QuotaWarningFraction float64
+}
+
+// Webhook configures the notifications POSTed to an HTTP endpoint
+// (Ant.Webhook).
+type Webhook struct {
+	// URL is the endpoint the events are POSTed to. Empty disables the
+	// notifications.
+	URL string
+	// Secret is the key of the HMAC-SHA256 signature of the payloads.
+	Secret string
+	// Events are the types of the events sent. Empty sends the cheques, the
+	// cash-outs and the alerts.
+	Events []string
+	// Timeout bounds a delivery attempt, e.g. "10s".
+	Timeout string
+	// MaxAttempts is the number of attempts before an event is recorded as a
+	// dead letter.
+	MaxAttempts int
+}
+
+// Scrub configures the re-hashing of the stored blocks (Ant.Scrub).
+type Scrub struct {
+	Enabled bool
+	// BlocksPerMinute is the number of blocks checked per minute.
+	BlocksPerMinute int
+	// PassInterval is the pause between two passes over the blocks, e.g.
+	// "24h".
+	PassInterval string
+	// RefetchTimeout bounds the fetch of a corrupt block, e.g. "2m".
+	RefetchTimeout string
+}
+
+// Migration limits the migrations of blocks from other ants (Ant.Migration).
+type Migration struct {
+	// Workers is the number of blocks fetched concurrently.
+	Workers int
+	// PerAntConcurrency is the number of blocks fetched concurrently from
+	// the same source ant.
+	PerAntConcurrency int
+	// BlockTimeout bounds the fetch of a single block, e.g. "2m".
+	BlockTimeout string
+	// SourceTimeout bounds the fetch of a block from the source ant before
+	// falling back to the content discovery, e.g. "1m".
+	SourceTimeout string
+	// MaxBandwidth caps the bytes fetched per second, e.g. "10MB". Empty
+	// means no cap.
+	MaxBandwidth string
+	// DagTimeout bounds the fetch of a DAG, e.g. "1h".
+	DagTimeout string
+	// ResultBatchSize is the number of results of an ant reported to the
+	// queen at once.
+	ResultBatchSize int
+	// ResultFlushInterval is how long results wait for their batch to fill
+	// before being reported, e.g. "10s".
+	ResultFlushInterval string
+}
+
+// Storage limits the blocks pushed by the queens (Ant.Storage).
+type Storage struct {
+	// Quota is the maximum size of the repo, e.g. "500GB". Empty means no
+	// limit.
+	Quota string
+	// QuotaFraction is the maximum fraction of the space available to the
+	// repo, its size plus the free disk space. 0 means no limit.
+	QuotaFraction float64
+	// MaxBlockSize is the maximum size of a pushed block, e.g. "2MiB".
+	MaxBlockSize string
+}
+
+// Roster configures the key which signs the queen rosters (Ant.Roster).
+type Roster struct {
+	// Signer is the ethereum address of the roster key. Without it the
+	// unsigned queen lists of the queens are accepted.
+	Signer string
+}
+
+// Authorization configures which peers may send mine protocol messages
+// (Ant.Authorization).
+type Authorization struct {
+	// AllowedPeers are peer IDs accepted in addition to the queens, e.g. for
+	// testing against a local queen.
+	AllowedPeers []string
+}
+
+// CashOutPolicy configures the automatic cash-out of cheques (Ant.CashOut).
+type CashOutPolicy struct {
+	// Enabled turns the automatic cash-out loop on.
+	Enabled bool
+	// Threshold is the minimum uncashed amount of a chequebook, in ANTZ.
+	Threshold string
+	// Interval is the minimum time between two cash-out runs, e.g. "24h".
+	Interval string
+	// MaxGasPrice is the gas price ceiling in gwei, runs are skipped above it.
+	MaxGasPrice string
+	// MinBNBBalance is the BNB reserve, runs are skipped below it.
+	MinBNBBalance string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		CashOut: CashOutPolicy{
+			Enabled:       false,
+			Threshold:     "100",
+			Interval:      "24h",
+			MaxGasPrice:   "20",
+			MinBNBBalance: "0.01",
+		},
+		Storage: Storage{
+			MaxBlockSize: "2MiB",
+		},
+		Migration: Migration{
+			Workers:             8,
+			PerAntConcurrency:   2,
+			BlockTimeout:        "2m",
+			SourceTimeout:       "1m",
+			DagTimeout:          "1h",
+			ResultBatchSize:     100,
+			ResultFlushInterval: "10s",
+		},
+		Scrub: Scrub{
+			Enabled:         true,
+			BlocksPerMinute: 60,
+			PassInterval:    "24h",
+			RefetchTimeout:  "2m",
+		},
+		Alerts: Alerts{
+			CheckInterval:         "1m",
+			MinBNBBalance:         "0.05",
+			QueenUnreachableAfter: "10m",
+			QuotaWarningFraction:  0.9,
+		},
+		Webhook: Webhook{
+			Timeout:     "10s",
+			MaxAttempts: 6,
+		},
+	}
+}
+
+func (c Migration) migrationConfig() (migration.Config, error) {
+	cfg := migration.Config{
+		Workers:           c.Workers,
+		PerAntConcurrency: c.PerAntConcurrency,
+		BlockTimeout:      parseDuration(c.BlockTimeout, migration.DefaultConfig().BlockTimeout),
+		SourceTimeout:     parseDuration(c.SourceTimeout, migration.DefaultConfig().SourceTimeout),
+		DagTimeout:        parseDuration(c.DagTimeout, migration.DefaultConfig().DagTimeout),
+	}
+	if c.MaxBandwidth != "" {
+		bytesPerSecond, err := humanize.ParseBytes(c.MaxBandwidth)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid migration max bandwidth %q: %w", c.MaxBandwidth, err)
+		}
+		cfg.BytesPerSecond = bytesPerSecond
+	}
+	return cfg, nil
+}
+
+func parseDuration(str string, def time.Duration) time.Duration {
+	if str == "" {
+		return def
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		log.Errorf("invalid duration %q, using %v: %v", str, def, err)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,122 @@
+package mineservice
+
+import (
+	"context"
+	proto "github.com/antnest-network/ant-proto"
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/core/mine/migration"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/pkg/xcontext"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"time"
+)
+
+// HandleMigrateDagMessage queues the migration of the DAGs of the request.
+// The request is refused when graphsync is not enabled, roots with an
+// invalid cid or selector are skipped.
+func (m *MineService) HandleMigrateDagMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	req, ok := msg.(*mineproto.MigrateDagReq)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	log.Infof("received migrate dag message from %v for %v roots", from, len(req.Roots))
+	code := proto.Success
+	if m.migrator.DagSupported() {
+		fromAnt := peer.AddrInfo{ID: peer.ID(req.FromAnt)}
+		for _, v := range req.FromAntAddrs {
+			addr, err := ma.NewMultiaddr(v)
+			if err != nil {
+				log.Errorf("NewMultiaddr: %v", err)
+				continue
+			}
+			fromAnt.Addrs = append(fromAnt.Addrs, addr)
+		}
+		for _, root := range req.Roots {
+			rcid, err := cid.Decode(root.Root)
+			if err != nil {
+				log.Errorf("failed to decode cid: %v", err)
+				continue
+			}
+			if _, err := migration.DecodeSelector(root.Selector); err != nil {
+				log.Errorf("refused migration of dag %v: %v", rcid, err)
+				continue
+			}
+			m.migrator.AsyncMigrateDag(fromAnt, rcid, root.Selector, req.Recursive)
+		}
+	} else {
+		log.Warnf("refused migration of dags from %v: %v", from, migration.ErrGraphsyncDisabled)
+		code = proto.Failure
+	}
+
+	resp := ant_pro.MigrateBlockResp{
+		Seq:  req.Seq,
+		Code: int32(code),
+	}
+	err := xcontext.Do(ctx, func(ctx context.Context) error {
+		return m.messenger.RespondMigrateBlock(ctx, from, &resp)
+	}, xcontext.WithTimeout(time.Second*10), xcontext.WithTryCount(3))
+	if err != nil {
+		log.Errorf("failed to RespondMigrateBlock: %v", err)
+	}
+}
+
+// OnMigrateDagProgress reports the progress of the DAG migration to the queen,
+// a report which cannot be sent is dropped.
+func (m *MineService) OnMigrateDagProgress(ant peer.ID, root cid.Cid, progress migration.DagProgress) {
+	to := m.queenManager.GetQueen()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	sender, ok := m.messenger.(mineproto.MessageSender)
+	if !ok {
+		return
+	}
+	err := sender.SendMessage(ctx, mineproto.ProtocolMigrateDagProgress, to.ID, &mineproto.MigrateDagProgress{
+		FromAnt: string(ant),
+		Root:    root.String(),
+		Blocks:  progress.Blocks,
+		Bytes:   progress.Bytes,
+	})
+	if err != nil {
+		log.Debugf("failed to send progress of dag %v: %v", root, err)
+	}
+}
+
+// OnMigrateDagDone indexes the migrated DAG and queues the outcome to report
+// to the queen. A DAG pinned recursively is indexed under its root, the blocks of a
+// DAG pinned directly are indexed one by one.
+func (m *MineService) OnMigrateDagDone(ant peer.ID, root cid.Cid, code int, progress migration.DagProgress, blocks map[cid.Cid]uint64) error {
+	m.events.Publish(EventDagMigrated, &DagMigratedEvent{
+		Root:    root.String(),
+		FromAnt: ant.String(),
+		Blocks:  progress.Blocks,
+		Bytes:   progress.Bytes,
+		Code:    code,
+	})
+	if code == proto.Success {
+		storedBytes.WithLabelValues(BlockOriginDagMigration).Add(float64(progress.Bytes))
+		if blocks == nil {
+			if err := m.blockIndex.Add(root, progress.Bytes, ant.String(), BlockOriginDagMigration); err != nil {
+				log.Errorf("failed to index dag %v: %v", root, err)
+			}
+		}
+		for bcid, size := range blocks {
+			if err := m.blockIndex.AddDagBlock(bcid, size, ant.String(), root); err != nil {
+				log.Errorf("failed to index block %v of dag %v: %v", bcid, root, err)
+			}
+		}
+	}
+	err := m.results.AddDag(ant, &DagResult{
+		Root:   root.String(),
+		Blocks: progress.Blocks,
+		Bytes:  progress.Bytes,
+		Code:   int32(code),
+	})
+	if err != nil {
+		log.Errorf("failed to queue result of dag %v: %v", root, err)
+		return err
+	}
+	return nil
+}
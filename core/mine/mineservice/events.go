@@ -0,0 +1,310 @@
+package mineservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEventRetention is the number of recent events kept for replay.
+	DefaultEventRetention = 1000
+
+	// eventSubscriberBuffer is the number of live events a subscriber may
+	// lag behind before it is dropped.
+	eventSubscriberBuffer = 256
+
+	// eventSeqLease is the number of sequence numbers reserved in the state
+	// store at a time, the unused ones are skipped after a restart.
+	eventSeqLease = 1000
+	eventSeqKey   = "/events/seq"
+)
+
+const (
+	EventBlockPushed      = "block-pushed"
+	EventBlockMigrated    = "block-migrated"
+	EventDagMigrated      = "dag-migrated"
+	EventChequeReceived   = "cheque-received"
+	EventChequeRejected   = "cheque-rejected"
+	EventCashOutSubmitted = "cashout-submitted"
+	EventCashOutFinished  = "cashout-finished"
+	EventBalanceLow       = "balance-low"
+	EventPledgeMissing    = "pledge-missing"
+	EventQueenUnreachable = "queen-unreachable"
+	EventQuotaNearlyFull  = "quota-nearly-full"
+)
+
+// EventTypes lists the types of the mine events.
+var EventTypes = []string{
+	EventBlockPushed,
+	EventBlockMigrated,
+	EventDagMigrated,
+	EventChequeReceived,
+	EventChequeRejected,
+	EventCashOutSubmitted,
+	EventCashOutFinished,
+	EventBalanceLow,
+	EventPledgeMissing,
+	EventQueenUnreachable,
+	EventQuotaNearlyFull,
+}
+
+// Event is an event of the mine service. Data is one of the event structs
+// below, depending on Type.
+type Event struct {
+	Seq  uint64
+	Time time.Time
+	Type string
+	Data interface{}
+}
+
+// BlockPushedEvent is a block pushed by a queen, stored or refused.
+type BlockPushedEvent struct {
+	Cid   string
+	From  string
+	Size  int
+	Code  int32
+	Error string `json:",omitempty"`
+}
+
+// BlockMigratedEvent is the outcome of the migration of a block.
+type BlockMigratedEvent struct {
+	Cid     string
+	FromAnt string
+	Code    int
+}
+
+// DagMigratedEvent is the outcome of the migration of a DAG.
+type DagMigratedEvent struct {
+	Root    string
+	FromAnt string
+	Blocks  uint64
+	Bytes   uint64
+	Code    int
+}
+
+// ChequeReceivedEvent is an accepted cheque.
+type ChequeReceivedEvent struct {
+	Chequebook       string
+	From             string
+	CumulativePayout string
+}
+
+// ChequeRejectedEvent is a rejected cheque.
+type ChequeRejectedEvent struct {
+	Chequebook string
+	From       string
+	Reason     string
+}
+
+// BalanceLowEvent is a BNB balance of the wallet under the alert threshold.
+type BalanceLowEvent struct {
+	Address   string
+	Balance   string
+	Threshold string
+}
+
+// PledgeMissingEvent is a node without tokens locked in the locker contract.
+type PledgeMissingEvent struct {
+	NodeId string
+}
+
+// QueenUnreachableEvent is a queen which did not answer the pings for a while.
+type QueenUnreachableEvent struct {
+	Queen     string
+	LastPong  time.Time
+	LastError string `json:",omitempty"`
+}
+
+// QuotaNearlyFullEvent is a storage usage above the warning fraction of the
+// quota.
+type QuotaNearlyFullEvent struct {
+	Usage    uint64
+	Limit    uint64
+	Fraction float64
+}
+
+// ValidEventType returns an error if typ is not a mine event type.
+func ValidEventType(typ string) error {
+	for _, t := range EventTypes {
+		if t == typ {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type %q, expected one of %v", typ, EventTypes)
+}
+
+type eventHook struct {
+	fn    func(Event)
+	types map[string]bool
+}
+
+type eventSubscriber struct {
+	ch    chan Event
+	types map[string]bool
+}
+
+func (s *eventSubscriber) wants(typ string) bool {
+	return len(s.types) == 0 || s.types[typ]
+}
+
+// EventBus publishes the events of the mine service to its subscribers and
+// keeps the recent ones for replay. Events are kept in memory only, their
+// sequence numbers are leased from the state store so that they keep growing
+// across restarts.
+type EventBus struct {
+	lock        sync.Mutex
+	stateStore  statestore.StateStore
+	seq         uint64
+	leased      uint64
+	retention   int
+	events      []Event
+	hooks       []eventHook
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func NewEventBus(retention int, stateStore statestore.StateStore) (*EventBus, error) {
+	if retention <= 0 {
+		retention = DefaultEventRetention
+	}
+	var seq uint64
+	if err := stateStore.Get(datastore.NewKey(eventSeqKey), &seq); err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	return &EventBus{
+		stateStore:  stateStore,
+		seq:         seq,
+		leased:      seq,
+		retention:   retention,
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}, nil
+}
+
+// nextSeq returns the next sequence number, leasing more from the state
+// store when the lease is used up.
+func (b *EventBus) nextSeq() uint64 {
+	b.seq++
+	if b.seq > b.leased {
+		leased := b.seq + eventSeqLease - 1
+		if err := b.stateStore.Put(datastore.NewKey(eventSeqKey), leased); err != nil {
+			log.Errorf("failed to save the event sequence number: %v", err)
+		} else {
+			b.leased = leased
+		}
+	}
+	return b.seq
+}
+
+// Publish publishes an event, it never blocks. A subscriber too slow to take
+// the event is dropped, its channel is closed.
+func (b *EventBus) Publish(typ string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	event := Event{
+		Seq:  b.nextSeq(),
+		Time: time.Now(),
+		Type: typ,
+		Data: data,
+	}
+	b.events = append(b.events, event)
+	if len(b.events) > b.retention {
+		b.events = b.events[len(b.events)-b.retention:]
+	}
+	for _, hook := range b.hooks {
+		if len(hook.types) == 0 || hook.types[typ] {
+			hook.fn(event)
+		}
+	}
+	for sub := range b.subscribers {
+		if !sub.wants(typ) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			log.Warnf("dropped a mine event subscriber lagging behind at event %v", event.Seq)
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// OnPublish calls fn with every event of the given types, or of any type if
+// none is given, as it is published and in order. fn is called with the bus
+// locked, it must not publish.
+func (b *EventBus) OnPublish(fn func(Event), types ...string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.hooks = append(b.hooks, eventHook{fn: fn, types: typeSet(types)})
+}
+
+// Events returns the retained events after the sequence number, of the
+// given types or of any type if none is given.
+func (b *EventBus) Events(since uint64, types ...string) []Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.replay(since, typeSet(types))
+}
+
+func (b *EventBus) replay(since uint64, types map[string]bool) []Event {
+	sub := &eventSubscriber{types: types}
+	var list []Event
+	for _, event := range b.events {
+		if event.Seq > since && sub.wants(event.Type) {
+			list = append(list, event)
+		}
+	}
+	return list
+}
+
+// Subscribe returns the retained events after the sequence number followed
+// by the new events, of the given types or of any type if none is given. The
+// channel is closed when ctx is done or when the subscriber lags behind, it
+// can then subscribe again after the last event it received.
+func (b *EventBus) Subscribe(ctx context.Context, since uint64, types ...string) <-chan Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	set := typeSet(types)
+	replay := b.replay(since, set)
+	sub := &eventSubscriber{
+		ch:    make(chan Event, len(replay)+eventSubscriberBuffer),
+		types: set,
+	}
+	for _, event := range replay {
+		sub.ch <- event
+	}
+	b.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		b.unsubscribe(sub)
+	}()
+	return sub.ch
+}
+
+func (b *EventBus) unsubscribe(sub *eventSubscriber) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+func typeSet(types []string) map[string]bool {
+	set := make(map[string]bool, len(types))
+	for _, t := range types {
+		set[t] = true
+	}
+	return set
+}
@@ -0,0 +1,154 @@
+package mineservice
+
+import (
+	proto "github.com/antnest-network/ant-proto"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	unauthorizedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "unauthorized_messages_total",
+		Help:      "Number of mine protocol messages rejected because the sender is not an authorized queen.",
+	}, []string{"protocol"})
+
+	queenSuccessRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_ping_success_rate",
+		Help:      "Moving average of the ping success rate of a queen.",
+	}, []string{"queen"})
+
+	queenRTT = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_ping_rtt_seconds",
+		Help:      "Moving average of the ping round-trip time of a queen.",
+	}, []string{"queen"})
+
+	queenPingFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_ping_failures_total",
+		Help:      "Number of failed pings to a queen.",
+	}, []string{"queen"})
+
+	queenDown = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_down",
+		Help:      "Whether a queen is backed off after failed pings.",
+	}, []string{"queen"})
+)
+
+var (
+	queenPingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_ping_duration_seconds",
+		Help:      "Round-trip time of the successful pings to a queen.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"queen"})
+
+	queenReports = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "queen_reports_total",
+		Help:      "Number of reports delivered to a queen.",
+	}, []string{"queen"})
+
+	pushedBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "pushed_blocks_total",
+		Help:      "Number of blocks pushed by the queens, by result.",
+	}, []string{"result"})
+
+	pushedBlockSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "pushed_block_size_bytes",
+		Help:      "Size of the accepted pushed blocks.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	})
+
+	storedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "stored_bytes_total",
+		Help:      "Number of bytes stored for the queens, by origin.",
+	}, []string{"origin"})
+
+	chequesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "cheques_received_total",
+		Help:      "Number of cheques received from the queens, by result.",
+	}, []string{"result"})
+
+	migrationResultsPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "migration_results_pending",
+		Help:      "Number of migration results not acknowledged by the queens.",
+	})
+
+	scrubbedBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "scrubbed_blocks_total",
+		Help:      "Number of stored blocks re-hashed by the scrubber, by result.",
+	}, []string{"result"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "mine",
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of webhook delivery attempts by result: delivered, retried or dead.",
+	}, []string{"result"})
+)
+
+// pushResult is the label of the result of a pushed block.
+func pushResult(code int32) string {
+	switch code {
+	case proto.Success:
+		return "accepted"
+	case mineproto.CodeInvalidCid:
+		return "invalid_cid"
+	case mineproto.CodeBlockTooLarge:
+		return "too_large"
+	case mineproto.CodeHashMismatch:
+		return "hash_mismatch"
+	case mineproto.CodeQuotaExceeded:
+		return "quota_exceeded"
+	default:
+		return "failed"
+	}
+}
+
+// chequeRejectReason is the label of the reason a cheque was rejected.
+func chequeRejectReason(err error) string {
+	switch err {
+	case ErrInvalidChequebook:
+		return "invalid_chequebook"
+	case ErrInvalidBeneficiary:
+		return "invalid_beneficiary"
+	case ErrInvalidPayout:
+		return "invalid_payout"
+	case ErrInvalidSignature:
+		return "invalid_signature"
+	case ErrIssuerMismatch:
+		return "issuer_mismatch"
+	case ErrIssuerUnavailable:
+		return "issuer_unavailable"
+	case ErrBeneficiaryMismatch:
+		return "beneficiary_mismatch"
+	case ErrChequeNotIncreasing:
+		return "not_increasing"
+	default:
+		return "other"
+	}
+}
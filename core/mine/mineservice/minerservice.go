@@ -2,19 +2,28 @@ package mineservice
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	block2 "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-graphsync"
 	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs/core/mine/chain"
 	"github.com/ipfs/go-ipfs/core/mine/crypto"
 	"github.com/ipfs/go-ipfs/core/mine/migration"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
 	"github.com/ipfs/go-ipfs/core/mine/statestore"
 	"github.com/ipfs/go-ipfs/core/mine/transaction"
 	"github.com/ipfs/go-ipfs/pkg/xcontext"
+	"github.com/ipfs/go-ipfs/repo"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
 	proto "github.com/antnest-network/ant-proto"
 	ant_pro "github.com/antnest-network/ant-proto/pb"
 	"sync"
@@ -25,6 +34,13 @@ var (
 	log = logging.Logger("mineservice")
 )
 
+const (
+	minLockTokenBackoff = 10 * time.Second
+	maxLockTokenBackoff = 5 * time.Minute
+
+	heldChequeInterval = time.Minute
+)
+
 func init() {
 	logging.SetLogLevel("mineservice", "info")
 }
@@ -35,11 +51,24 @@ type MineService struct {
 	messenger          proto.Messenger
 	blockService       blockservice.BlockService
 	chequeStore        *ChequeStore
+	chequeManager      *ChequeManager
+	chequeValidator    *ChequeValidator
+	autoCashOut        *autoCashOut
 	transactionService transaction.Service
+	chx                chain.Chain
 	signer             crypto.Signer
 	migrator           *migration.Migrator
+	results            *resultReporter
+	scrubber           *scrubber
+	alerts             *alertMonitor
+	webhook            *webhookNotifier
 	queenManager       *QueenManager
+	authorizer         *Authorizer
+	admission          *blockAdmission
+	blockIndex         *BlockIndex
+	events             *EventBus
 	walletAddress      common.Address
+	config             Config
 
 	mutex  sync.RWMutex
 	wg     sync.WaitGroup
@@ -47,34 +76,139 @@ type MineService struct {
 }
 
 func New(h host.Host, messenger proto.Messenger, pinning pin.Pinner, blockService blockservice.BlockService,
-	stateStore statestore.StateStore, transactionService transaction.Service, signer crypto.Signer, queens []peer.AddrInfo) *MineService {
+	gs graphsync.GraphExchange, stateStore statestore.StateStore, r repo.Repo, chx chain.Chain, chequeManager *ChequeManager, signer crypto.Signer,
+	events *EventBus, queens []peer.AddrInfo, cfg Config) (*MineService, error) {
+	autoCashOut, err := newAutoCashOut(cfg.CashOut, chequeManager, chx, signer, stateStore)
+	if err != nil {
+		return nil, err
+	}
+	var rosterSigner common.Address
+	if cfg.Roster.Signer != "" {
+		if !common.IsHexAddress(cfg.Roster.Signer) {
+			return nil, fmt.Errorf("invalid roster signer %q", cfg.Roster.Signer)
+		}
+		rosterSigner = common.HexToAddress(cfg.Roster.Signer)
+	}
+	admission, err := newBlockAdmission(cfg.Storage, r)
+	if err != nil {
+		return nil, err
+	}
+	migrationConfig, err := cfg.Migration.migrationConfig()
+	if err != nil {
+		return nil, err
+	}
+	queenManager := NewQueenManager(queens, stateStore, rosterSigner)
+	authorizer, err := NewAuthorizer(queenManager, cfg.Authorization.AllowedPeers)
+	if err != nil {
+		return nil, err
+	}
 	m := &MineService{
 		p2pHost:            h,
 		messenger:          messenger,
 		pinning:            pinning,
 		blockService:       blockService,
 		signer:             signer,
-		chequeStore:        NewChequeStore(stateStore),
-		transactionService: transactionService,
-		queenManager:       NewQueenManager(queens),
+		chequeStore:        chequeManager.chequeStore,
+		chequeManager:      chequeManager,
+		autoCashOut:        autoCashOut,
+		chequeValidator:    NewChequeValidator(chx.TransactionService(), signer, chx.ChainID),
+		transactionService: chx.TransactionService(),
+		chx:                chx,
+		queenManager:       queenManager,
+		authorizer:         authorizer,
+		admission:          admission,
+		blockIndex:         NewBlockIndex(stateStore),
+		events:             events,
+		config:             cfg,
 	}
 
-	m.migrator = migration.NewMigrator(m, blockService, pinning)
+	resultBatchSize := cfg.Migration.ResultBatchSize
+	if resultBatchSize <= 0 {
+		resultBatchSize = DefaultConfig().Migration.ResultBatchSize
+	}
+	m.results = newResultReporter(stateStore, m.sendResultBatch, resultBatchSize,
+		parseDuration(cfg.Migration.ResultFlushInterval, 10*time.Second))
+	ethAddress, err := signer.EthereumAddress()
+	if err != nil {
+		return nil, err
+	}
+	m.walletAddress = ethAddress
+	m.alerts, err = newAlertMonitor(cfg.Alerts, chx, ethAddress, h.ID().String(), queenManager, admission, events)
+	if err != nil {
+		return nil, err
+	}
+	m.webhook, err = newWebhookNotifier(cfg.Webhook, h.ID().String(), events, stateStore)
+	if err != nil {
+		return nil, err
+	}
+	m.scrubber = newScrubber(blockService, m.blockIndex, stateStore, m.sendBlocksLost, cfg.Scrub)
+	m.migrator = migration.NewMigrator(m, m, h, blockService, gs, pinning, stateStore, migrationConfig)
 	m.messenger.SetMessageHandler(proto.ProtocolPingMessage, m.HandlePingMessage)
-	m.messenger.SetMessageHandler(proto.ProtocolPushBlockMessage, m.HandlePushBlockMessage)
-	m.messenger.SetMessageHandler(proto.ProtocolMigrateBlockMessage, m.HandleMigrateBlockMessage)
-	m.messenger.SetMessageHandler(proto.ProtocolCheque, m.HandleChequeMessage)
-	m.messenger.SetMessageHandler(proto.ProtocolQueens, m.queenManager.HandleQueenMessage)
+	m.setAuthorizedHandler(proto.ProtocolPushBlockMessage, m.HandlePushBlockMessage)
+	m.setAuthorizedHandler(proto.ProtocolMigrateBlockMessage, m.HandleMigrateBlockMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolMigrateBlock, m.HandleMigrateBlockAddrsMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolMigrateDag, m.HandleMigrateDagMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolMigrateBlockResultsAck, m.HandleMigrateBlockResultsAckMessage)
+	m.setAuthorizedHandler(proto.ProtocolCheque, m.HandleChequeMessage)
+	m.setAuthorizedHandler(proto.ProtocolQueens, m.queenManager.HandleQueenMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolQueenRoster, m.queenManager.HandleQueenRosterMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolStorageChallenge, m.HandleStorageChallengeMessage)
+	m.setAuthorizedHandler(mineproto.ProtocolReleaseBlocks, m.HandleReleaseBlocksMessage)
+
+	return m, nil
+}
 
-	return m
+// setAuthorizedHandler sets a message handler which only accepts messages
+// from authorized queens.
+func (m *MineService) setAuthorizedHandler(pid protocol.ID, h proto.MessageHandler) {
+	m.messenger.SetMessageHandler(pid, m.authorizer.Wrap(pid, h))
 }
 
 func (m *MineService) Start() error {
-	m.migrator.Start()
+	if err := m.results.load(); err != nil {
+		return err
+	}
+	if err := m.migrator.Start(); err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	m.cancel = cancel
 
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.results.run(ctx)
+	}()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.lockToken(ctx)
+	}()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.alerts.run(ctx)
+	}()
+
+	if m.config.Webhook.URL != "" {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.webhook.run(ctx)
+		}()
+	}
+
+	if m.config.Scrub.Enabled {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.scrubber.run(ctx)
+		}()
+	}
+
 	m.wg.Add(1)
 	go func() {
 		defer m.wg.Done()
@@ -88,6 +222,20 @@ func (m *MineService) Start() error {
 			}
 		}
 	}()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.validateHeldCheques(ctx)
+	}()
+
+	if m.config.CashOut.Enabled {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.autoCashOut.loop(ctx)
+		}()
+	}
 	return nil
 }
 
@@ -101,18 +249,86 @@ func (m *MineService) Stop() error {
 	return nil
 }
 
+// lockToken locks the pledge of the node in the locker contract, retrying
+// with a backoff until it succeeds so that the node keeps serving while the
+// chain is unavailable. A retry waits for the transactions already sent
+// rather than sending them again.
+func (m *MineService) lockToken(ctx context.Context) {
+	backoff := minLockTokenBackoff
+	for {
+		err := m.chx.LockToken(ctx, m.p2pHost.ID().String(), m.walletAddress)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warnf("failed to lock token, retrying in %v: %v", backoff, err)
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff *= 2
+		if backoff > maxLockTokenBackoff {
+			backoff = maxLockTokenBackoff
+		}
+	}
+}
+
+// PingQueen pings the queens which are not backed off and records their
+// health.
 func (m *MineService) PingQueen(ctx context.Context) {
-	p := m.queenManager.GetQueen()
+	var wg sync.WaitGroup
+	for _, p := range m.queenManager.QueensToPing() {
+		wg.Add(1)
+		go func(p peer.AddrInfo) {
+			defer wg.Done()
+			_, _ = m.pingQueen(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// PingQueenByID pings the known queen immediately and returns the round-trip
+// time.
+func (m *MineService) PingQueenByID(ctx context.Context, id peer.ID) (time.Duration, error) {
+	p, ok := m.queenManager.Queen(id)
+	if !ok {
+		return 0, ErrUnknownQueen
+	}
+	return m.pingQueen(ctx, p)
+}
+
+func (m *MineService) pingQueen(ctx context.Context, p peer.AddrInfo) (time.Duration, error) {
+	if len(p.Addrs) > 0 {
+		m.p2pHost.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.TempAddrTTL)
+	}
 	ping := &ant_pro.Ping{}
+	start := time.Now()
 	err := xcontext.Do(ctx, func(ctx context.Context) error {
 		_, err := m.messenger.Ping(ctx, p.ID, ping)
 		return err
 	}, xcontext.WithTimeout(time.Second*10))
+	rtt := time.Since(start)
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	m.queenManager.ReportPing(p.ID, rtt, err)
 	if err != nil {
-		log.Errorf("failed to ping: %v", err)
-		return
+		log.Errorf("failed to ping %v: %v", p.ID.String(), err)
+		return 0, err
 	}
-	log.Infof("pong from %v", p.ID.String())
+	log.Infof("pong from %v in %v", p.ID.String(), rtt)
+	return rtt, nil
+}
+
+// QueenHealth returns the ping health of the active and bootstrap queens.
+func (m *MineService) QueenHealth() []QueenHealth {
+	return m.queenManager.Health()
+}
+
+// LastReportedQueen returns the queen the last report was sent to.
+func (m *MineService) LastReportedQueen() peer.ID {
+	return m.queenManager.LastReported()
 }
 
 func (m *MineService) HandlePingMessage(ctx context.Context, from peer.ID, msg interface{}) {
@@ -144,29 +360,26 @@ func (m *MineService) HandlePushBlockMessage(ctx context.Context, from peer.ID,
 	}
 
 	log.Infof("received block from %v, cid: %v", from, req.Cid)
-	bcid, err := cid.Decode(req.Cid)
-	if err != nil {
-		log.Errorf("failed to decode cid: %v", err)
-		return
-	}
-	block, err := block2.NewBlockWithCid(req.Data, bcid)
-	if err != nil {
-		log.Errorf("failed to NewBlockWithCid: %v", err)
-		return
-	}
-
 	resp := ant_pro.PushBlockResp{
 		Seq:  req.Seq,
 		Code: proto.Success,
 	}
-
-	err = m.blockService.AddBlock(block)
+	code, err := m.storePushedBlock(from, req)
 	if err != nil {
-		log.Errorf("failed to AddBlock: %v", err)
+		log.Warnf("refused block %v from %v: %v", req.Cid, from, err)
+		resp.Code = code
 		resp.ErrString = err.Error()
-		resp.Code = proto.Failure
+	} else {
+		pushedBlockSize.Observe(float64(len(req.Data)))
 	}
-	m.pinning.PinWithMode(bcid, pin.Direct)
+	pushedBlocks.WithLabelValues(pushResult(resp.Code)).Inc()
+	m.events.Publish(EventBlockPushed, &BlockPushedEvent{
+		Cid:   req.Cid,
+		From:  from.String(),
+		Size:  len(req.Data),
+		Code:  resp.Code,
+		Error: resp.ErrString,
+	})
 
 	err = xcontext.Do(ctx, func(ctx context.Context) error {
 		return m.messenger.RespondPushBlock(ctx, from, &resp)
@@ -177,6 +390,54 @@ func (m *MineService) HandlePushBlockMessage(ctx context.Context, from peer.ID,
 	}
 }
 
+// storePushedBlock admits, stores, pins and indexes the pushed block. On
+// failure it returns the code to answer the queen with.
+func (m *MineService) storePushedBlock(from peer.ID, req *ant_pro.PushBlockReq) (int32, error) {
+	bcid, err := cid.Decode(req.Cid)
+	if err != nil {
+		return mineproto.CodeInvalidCid, fmt.Errorf("invalid cid: %w", err)
+	}
+	if err := m.admission.check(bcid, req.Data); err != nil {
+		if err == ErrBlockTooLarge {
+			return mineproto.CodeBlockTooLarge, err
+		}
+		return mineproto.CodeHashMismatch, err
+	}
+	has, err := m.blockService.Blockstore().Has(bcid)
+	if err != nil {
+		return proto.Failure, err
+	}
+	// reserved is given back if the block is not stored
+	var reserved uint64
+	if !has {
+		if err := m.admission.reserve(uint64(len(req.Data))); err != nil {
+			if err == ErrQuotaExceeded {
+				return mineproto.CodeQuotaExceeded, err
+			}
+			return proto.Failure, err
+		}
+		reserved = uint64(len(req.Data))
+	}
+	block, err := block2.NewBlockWithCid(req.Data, bcid)
+	if err != nil {
+		m.admission.release(reserved)
+		return proto.Failure, err
+	}
+	if err := m.blockService.AddBlock(block); err != nil {
+		log.Errorf("failed to AddBlock: %v", err)
+		m.admission.release(reserved)
+		return proto.Failure, err
+	}
+	m.pinning.PinWithMode(bcid, pin.Direct)
+	if !has {
+		storedBytes.WithLabelValues(BlockOriginPush).Add(float64(len(req.Data)))
+	}
+	if err := m.blockIndex.Add(bcid, uint64(len(req.Data)), from.String(), BlockOriginPush); err != nil {
+		log.Errorf("failed to index block %v: %v", bcid, err)
+	}
+	return proto.Success, nil
+}
+
 func (m *MineService) HandleMigrateBlockMessage(ctx context.Context, from peer.ID, msg interface{}) {
 	req, ok := msg.(*ant_pro.MigrateBlockReq)
 	if !ok {
@@ -184,8 +445,33 @@ func (m *MineService) HandleMigrateBlockMessage(ctx context.Context, from peer.I
 		return
 	}
 	log.Infof("received migrate message from %v", from)
-	fromAnt := peer.ID(req.FromAnt)
-	for _, block := range req.Cids {
+	m.migrateBlocks(ctx, from, req.Seq, peer.AddrInfo{ID: peer.ID(req.FromAnt)}, req.Cids)
+}
+
+// HandleMigrateBlockAddrsMessage handles the migrate block message which
+// carries the addresses of the source ant.
+func (m *MineService) HandleMigrateBlockAddrsMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	req, ok := msg.(*mineproto.MigrateBlockReq)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	log.Infof("received migrate message from %v with %v addresses of the source ant", from, len(req.FromAntAddrs))
+	fromAnt := peer.AddrInfo{ID: peer.ID(req.FromAnt)}
+	for _, v := range req.FromAntAddrs {
+		addr, err := ma.NewMultiaddr(v)
+		if err != nil {
+			log.Errorf("NewMultiaddr: %v", err)
+			continue
+		}
+		fromAnt.Addrs = append(fromAnt.Addrs, addr)
+	}
+	m.migrateBlocks(ctx, from, req.Seq, fromAnt, req.Cids)
+}
+
+// migrateBlocks queues the migrations and acknowledges the request.
+func (m *MineService) migrateBlocks(ctx context.Context, from peer.ID, seq uint32, fromAnt peer.AddrInfo, cids []string) {
+	for _, block := range cids {
 		bcid, err := cid.Decode(block)
 		if err != nil {
 			log.Errorf("failed to decode cid: %v", err)
@@ -194,7 +480,7 @@ func (m *MineService) HandleMigrateBlockMessage(ctx context.Context, from peer.I
 		m.migrator.AsyncMigrate(fromAnt, bcid)
 	}
 	resp := ant_pro.MigrateBlockResp{
-		Seq:  req.Seq,
+		Seq:  seq,
 		Code: proto.Success,
 	}
 	err := xcontext.Do(ctx, func(ctx context.Context) error {
@@ -207,28 +493,138 @@ func (m *MineService) HandleMigrateBlockMessage(ctx context.Context, from peer.I
 	}
 }
 
+// OnMigrateBlockDone queues the result of the migration for the batched
+// report to the queens.
 func (m *MineService) OnMigrateBlockDone(ant peer.ID, bcid cid.Cid, code int) error {
-	msg := &ant_pro.MigrateBlockResult{
-		FromAnt: string(ant),
-		Blocks:  make([]*ant_pro.MigrateBlockResult_Block, 0),
+	if code == proto.Success {
+		m.indexMigratedBlock(ant, bcid)
 	}
-	msg.Blocks = append(msg.Blocks, &ant_pro.MigrateBlockResult_Block{
-		Cid:  bcid.String(),
-		Code: int32(code),
+	m.events.Publish(EventBlockMigrated, &BlockMigratedEvent{
+		Cid:     bcid.String(),
+		FromAnt: ant.String(),
+		Code:    code,
 	})
+	if err := m.results.Add(ant, bcid, code); err != nil {
+		log.Errorf("failed to queue migration result of %v: %v", bcid, err)
+		return err
+	}
+	return nil
+}
 
-	to := m.queenManager.GetQueen()
-	err := xcontext.Do(context.Background(), func(ctx context.Context) error {
-		return m.messenger.SendMigrateBlockResult(ctx, to.ID, msg)
-	}, xcontext.WithTimeout(time.Second*10), xcontext.WithTryCount(3))
+// OnMigrateInvalid reports a queued migration whose ant or cid does not
+// decode as failed.
+func (m *MineService) OnMigrateInvalid(ant peer.ID, c string, dag bool) error {
+	if dag {
+		err := m.results.AddDag(ant, &DagResult{Root: c, Code: int32(proto.Failure)})
+		if err != nil {
+			log.Errorf("failed to queue result of dag %v: %v", c, err)
+			return err
+		}
+		return nil
+	}
+	if err := m.results.add(ant, c, proto.Failure); err != nil {
+		log.Errorf("failed to queue migration result of %v: %v", c, err)
+		return err
+	}
+	return nil
+}
+
+// ReserveStorage reserves the storage of a migrated DAG in the quota.
+func (m *MineService) ReserveStorage(size uint64) error {
+	return m.admission.reserve(size)
+}
+
+// ReleaseStorage gives back the reservation of a DAG which was not pinned.
+func (m *MineService) ReleaseStorage(size uint64) {
+	m.admission.release(size)
+}
 
+func (m *MineService) indexMigratedBlock(ant peer.ID, bcid cid.Cid) {
+	size, err := m.blockService.Blockstore().GetSize(bcid)
 	if err != nil {
-		log.Errorf("failed to send migrate block result: %v", err)
+		log.Errorf("failed to get size of block %v: %v", bcid, err)
+		return
+	}
+	storedBytes.WithLabelValues(BlockOriginMigration).Add(float64(size))
+	if err := m.blockIndex.Add(bcid, uint64(size), ant.String(), BlockOriginMigration); err != nil {
+		log.Errorf("failed to index block %v: %v", bcid, err)
+	}
+}
+
+// Blocks returns the blocks stored for the queens ordered by arrival.
+func (m *MineService) Blocks() ([]*BlockRecord, error) {
+	return m.blockIndex.List()
+}
+
+// Block returns the index record of a block stored for the queens.
+func (m *MineService) Block(bcid cid.Cid) (*BlockRecord, error) {
+	return m.blockIndex.Get(bcid)
+}
+
+// BlockSummary totals the blocks stored for the queens by origin and by day.
+func (m *MineService) BlockSummary() (*BlockSummary, error) {
+	return m.blockIndex.Summary()
+}
+
+// ScrubState returns the progress of the scrubber.
+func (m *MineService) ScrubState() (*ScrubState, error) {
+	return m.scrubber.state()
+}
+
+// Quarantine returns the stored blocks found corrupt or missing which could
+// not be fetched again.
+func (m *MineService) Quarantine() ([]*QuarantineRecord, error) {
+	return m.scrubber.Quarantine()
+}
+
+func (m *MineService) sendBlocksLost(ctx context.Context, msg *mineproto.BlocksLost) error {
+	to := m.queenManager.GetQueen()
+	if err := m.sendMessage(ctx, mineproto.ProtocolBlocksLost, to.ID, msg); err != nil {
 		return err
 	}
+	m.queenManager.ReportSent(to.ID)
 	return nil
 }
 
+// CashOutPolicy returns the automatic cash-out policy.
+func (m *MineService) CashOutPolicy() CashOutPolicy {
+	return m.config.CashOut
+}
+
+// CashOutDecisions returns the decisions of the automatic cash-out loop,
+// ordered from oldest to newest.
+func (m *MineService) CashOutDecisions() ([]*CashOutDecision, error) {
+	return m.autoCashOut.decisions()
+}
+
+// Events returns the event bus of the mine service.
+func (m *MineService) Events() *EventBus {
+	return m.events
+}
+
+// TestWebhook sends a test payload to the webhook.
+func (m *MineService) TestWebhook(ctx context.Context) error {
+	return m.webhook.test(ctx)
+}
+
+// WebhookDeadLetters returns the payloads which could not be delivered to the
+// webhook, oldest first.
+func (m *MineService) WebhookDeadLetters() ([]*WebhookDeadLetter, error) {
+	return m.webhook.DeadLetters()
+}
+
+// QueenRoster returns the latest valid queen roster and the address of the
+// configured roster key.
+func (m *MineService) QueenRoster() (*RosterRecord, common.Address, error) {
+	roster, err := m.queenManager.Roster()
+	return roster, m.queenManager.RosterSigner(), err
+}
+
+// RejectedCheques returns the number of rejected cheques by reason.
+func (m *MineService) RejectedCheques() map[string]uint64 {
+	return m.chequeValidator.Rejected()
+}
+
 func (m *MineService) HandleChequeMessage(ctx context.Context, from peer.ID, msg interface{}) {
 	cheque, ok := msg.(*ant_pro.Cheque)
 	if !ok {
@@ -236,9 +632,89 @@ func (m *MineService) HandleChequeMessage(ctx context.Context, from peer.ID, msg
 		return
 	}
 	log.Infof("received cheque from %v, %v", from, cheque.String())
-	err := m.chequeStore.SaveCheque(cheque)
+	err := m.receiveCheque(ctx, from, cheque)
+	if !errors.Is(err, chain.ErrChainUnavailable) {
+		return
+	}
+	log.Warnf("holding cheque from %v until the chain is available, chequebook: %v", from, cheque.Chequebook)
+	if err := m.chequeStore.HoldCheque(cheque, from); err != nil {
+		log.Errorf("failed to hold cheque: %v", err)
+		return
+	}
+	chequesReceived.WithLabelValues("held").Inc()
+}
+
+// receiveCheque validates the cheque and saves it, or rejects it. It fails
+// with chain.ErrChainUnavailable, the cheque being neither accepted nor
+// rejected, while the chain cannot be reached.
+func (m *MineService) receiveCheque(ctx context.Context, from peer.ID, cheque *ant_pro.Cheque) error {
+	vctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	err := m.chequeValidator.Validate(vctx, cheque)
+	if errors.Is(err, chain.ErrChainUnavailable) {
+		return err
+	}
+	if err != nil {
+		m.rejectCheque(from, cheque, err)
+		return nil
+	}
+	err = m.chequeStore.ReceiveCheque(cheque, from)
+	if err == ErrChequeNotIncreasing || err == ErrInvalidPayout {
+		m.rejectCheque(from, cheque, err)
+		return nil
+	}
 	if err != nil {
 		log.Errorf("failed to save cheque: %v", err)
-		return
+		return err
+	}
+	chequesReceived.WithLabelValues("accepted").Inc()
+	m.events.Publish(EventChequeReceived, &ChequeReceivedEvent{
+		Chequebook:       cheque.Chequebook,
+		From:             from.String(),
+		CumulativePayout: cheque.CumulativePayout,
+	})
+	return nil
+}
+
+// validateHeldCheques validates the cheques held while the chain was
+// unavailable, until ctx is done.
+func (m *MineService) validateHeldCheques(ctx context.Context) {
+	ticker := time.NewTicker(heldChequeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if _, err := m.chx.ChainID(); err != nil {
+			continue
+		}
+		list, err := m.chequeStore.HeldCheques()
+		if err != nil {
+			log.Errorf("failed to list held cheques: %v", err)
+			continue
+		}
+		for _, held := range list {
+			from, err := peer.Decode(held.From)
+			if err != nil {
+				log.Errorf("invalid sender of held cheque %v: %v", held.Cheque.Chequebook, err)
+			} else if err := m.receiveCheque(ctx, from, held.Cheque); err != nil {
+				break
+			}
+			if err := m.chequeStore.ReleaseHeldCheque(held); err != nil {
+				log.Errorf("failed to release held cheque %v: %v", held.Cheque.Chequebook, err)
+			}
+		}
 	}
 }
+
+func (m *MineService) rejectCheque(from peer.ID, cheque *ant_pro.Cheque, err error) {
+	m.chequeValidator.Reject(err)
+	log.Warnf("rejected cheque from %v, chequebook: %v, reason: %v", from, cheque.Chequebook, err)
+	m.events.Publish(EventChequeRejected, &ChequeRejectedEvent{
+		Chequebook: cheque.Chequebook,
+		From:       from.String(),
+		Reason:     err.Error(),
+	})
+}
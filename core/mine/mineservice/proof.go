@@ -0,0 +1,124 @@
+package mineservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/pkg/xcontext"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"time"
+)
+
+const (
+	maxChallengeTargets = 1024
+	// defaultChallengeDeadline applies to challenges without a deadline.
+	defaultChallengeDeadline = time.Minute
+)
+
+var (
+	ErrMessageSenderUnsupported = errors.New("messenger cannot send mine protocol messages")
+)
+
+// HandleStorageChallengeMessage answers a proof-of-storage challenge with the
+// nonce-salted hashes of the challenged blocks, signed by the wallet. Blocks
+// which are not held are listed as missing. The proof is sent when every
+// target was handled or at the deadline, the targets not reached by then
+// are neither proven nor listed.
+func (m *MineService) HandleStorageChallengeMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	challenge, ok := msg.(*mineproto.StorageChallenge)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	log.Infof("received storage challenge from %v for %v blocks", from, len(challenge.Targets))
+	if len(challenge.Targets) > maxChallengeTargets {
+		log.Warnf("refused storage challenge from %v: %v targets, at most %v allowed", from,
+			len(challenge.Targets), maxChallengeTargets)
+		return
+	}
+
+	deadline := time.Now().Add(defaultChallengeDeadline)
+	if challenge.Deadline > 0 {
+		deadline = time.Unix(0, challenge.Deadline*int64(time.Millisecond))
+	}
+	pctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	proof, err := m.proveStorage(pctx, challenge)
+	if err != nil {
+		log.Errorf("failed to prove storage to %v: %v", from, err)
+		return
+	}
+	err = m.sendMessage(ctx, mineproto.ProtocolStorageProof, from, proof)
+	if err != nil {
+		log.Errorf("failed to send storage proof to %v: %v", from, err)
+		return
+	}
+	log.Infof("sent storage proof to %v, %v proven, %v missing", from, len(proof.Proofs), len(proof.Missing))
+}
+
+func (m *MineService) proveStorage(ctx context.Context, challenge *mineproto.StorageChallenge) (*mineproto.StorageProof, error) {
+	proof := &mineproto.StorageProof{
+		Seq:   challenge.Seq,
+		Nonce: challenge.Nonce,
+	}
+	for _, target := range challenge.Targets {
+		if ctx.Err() != nil {
+			log.Warnf("storage challenge deadline reached, %v of %v targets handled",
+				len(proof.Proofs)+len(proof.Missing), len(challenge.Targets))
+			break
+		}
+		bcid, err := cid.Decode(target.Cid)
+		if err != nil {
+			proof.Missing = append(proof.Missing, target.Cid)
+			continue
+		}
+		block, err := m.blockService.Blockstore().Get(bcid)
+		if err != nil {
+			proof.Missing = append(proof.Missing, target.Cid)
+			continue
+		}
+		data := block.RawData()
+		offset, length := target.Offset, target.Length
+		if offset > uint64(len(data)) {
+			offset = uint64(len(data))
+		}
+		if length == 0 || offset+length > uint64(len(data)) {
+			length = uint64(len(data)) - offset
+		}
+		h := sha256.New()
+		h.Write(challenge.Nonce)
+		h.Write(data[offset : offset+length])
+		proof.Proofs = append(proof.Proofs, &mineproto.StorageProof_Proof{
+			Cid:    target.Cid,
+			Offset: offset,
+			Length: length,
+			Hash:   h.Sum(nil),
+		})
+	}
+
+	data, err := mineproto.ProofSigningData(proof)
+	if err != nil {
+		return nil, err
+	}
+	proof.Signature, err = m.signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// sendMessage sends a message of a protocol defined in mineproto.
+func (m *MineService) sendMessage(ctx context.Context, pid protocol.ID, to peer.ID, msg gogoproto.Message) error {
+	sender, ok := m.messenger.(mineproto.MessageSender)
+	if !ok {
+		return ErrMessageSenderUnsupported
+	}
+	return xcontext.Do(ctx, func(ctx context.Context) error {
+		return sender.SendMessage(ctx, pid, to, msg)
+	}, xcontext.WithTimeout(time.Second*10), xcontext.WithTryCount(3))
+}
@@ -3,24 +3,44 @@ package mineservice
 import (
 	"context"
 	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
 	"github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 	ant_pro "github.com/antnest-network/ant-proto/pb"
 	"math/rand"
 	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownQueen = errors.New("unknown queen")
 )
 
 type QueenManager struct {
 	bootstrapQueens []peer.AddrInfo
 	activeQueens    []peer.AddrInfo
-	currentIndex    int
+	activeSource    string // where the active queens come from
+	healths         map[peer.ID]*QueenHealth
+	lastReported    peer.ID
+	stateStore      statestore.StateStore
+	rosterSigner    common.Address
+	roster          *RosterRecord
 	sync.RWMutex
 }
 
-func NewQueenManager(bootstrapQueens []peer.AddrInfo) *QueenManager {
+// NewQueenManager creates a queen manager. If rosterSigner is set, the active
+// queens only come from rosters signed by it and the latest roster is
+// reloaded from the state store.
+func NewQueenManager(bootstrapQueens []peer.AddrInfo, stateStore statestore.StateStore, rosterSigner common.Address) *QueenManager {
 	m := QueenManager{
 		bootstrapQueens: bootstrapQueens,
+		healths:         make(map[peer.ID]*QueenHealth),
+		stateStore:      stateStore,
+		rosterSigner:    rosterSigner,
 	}
+	m.loadRoster()
 	return &m
 }
 
@@ -31,17 +51,42 @@ func (m *QueenManager) HandleQueenMessage(ctx context.Context, from peer.ID, msg
 		return
 	}
 	log.Infof("received queen message from %v", from)
+	if m.rosterSigner != (common.Address{}) {
+		log.Warnf("ignoring unsigned queen list from %v, a signed roster is required", from)
+		return
+	}
+	queens := pbQueens2Addresses(req.Queens)
+	m.Lock()
+	m.activeQueens = queens
+	m.activeSource = QueenSourceUnsigned
+	m.Unlock()
+}
+
+func (m *QueenManager) HandleQueenRosterMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	roster, ok := msg.(*mineproto.QueenRoster)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	log.Infof("received queen roster version %v from %v", roster.Version, from)
+	err := m.UpdateRoster(roster, from)
+	if err != nil {
+		log.Warnf("rejected queen roster version %v from %v: %v", roster.Version, from, err)
+		return
+	}
+	log.Infof("updated queen roster to version %v with %v queens", roster.Version, len(roster.Queens))
+}
+
+func pbQueens2Addresses(list []*ant_pro.Queens_Queen) []peer.AddrInfo {
 	var queens []peer.AddrInfo
-	for _, v := range req.Queens {
+	for _, v := range list {
 		addr, err := pbQueen2Address(v)
 		if err != nil {
 			continue
 		}
 		queens = append(queens, addr)
 	}
-	m.Lock()
-	m.activeQueens = queens
-	m.Unlock()
+	return queens
 }
 
 func pbQueen2Address(p *ant_pro.Queens_Queen) (peer.AddrInfo, error) {
@@ -61,13 +106,43 @@ func pbQueen2Address(p *ant_pro.Queens_Queen) (peer.AddrInfo, error) {
 	return ret, nil
 }
 
+// GetQueen returns the healthiest active queen. The bootstrap queens are only
+// used when every active queen is down.
 func (m *QueenManager) GetQueen() peer.AddrInfo {
 	m.RLock()
 	defer m.RUnlock()
 
-	if len(m.activeQueens) > 0 {
-		m.currentIndex = (m.currentIndex + 1) % len(m.activeQueens)
-		return m.activeQueens[m.currentIndex]
+	now := time.Now()
+	if up := m.healthiest(m.activeQueens, now); len(up) > 0 {
+		return up[0]
+	}
+	if len(m.bootstrapQueens) == 0 {
+		return peer.AddrInfo{}
+	}
+	bootstrapQueens := make([]peer.AddrInfo, len(m.bootstrapQueens))
+	for i, j := range rand.Perm(len(m.bootstrapQueens)) {
+		bootstrapQueens[i] = m.bootstrapQueens[j]
+	}
+	if up := m.healthiest(bootstrapQueens, now); len(up) > 0 {
+		return up[0]
+	}
+	return bootstrapQueens[0]
+}
+
+// IsQueen reports whether the peer is a bootstrap queen or an active queen.
+func (m *QueenManager) IsQueen(p peer.ID) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, q := range m.bootstrapQueens {
+		if q.ID == p {
+			return true
+		}
+	}
+	for _, q := range m.activeQueens {
+		if q.ID == p {
+			return true
+		}
 	}
-	return m.bootstrapQueens[rand.Intn(len(m.bootstrapQueens))]
+	return false
 }
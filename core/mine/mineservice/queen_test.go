@@ -0,0 +1,61 @@
+package mineservice
+
+import (
+	"context"
+	"testing"
+
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestQueenSource(t *testing.T) {
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	rosterSigner, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queens := []*ant_pro.Queens_Queen{{Id: "active", Addrs: []string{"/ip4/127.0.0.1/tcp/4001"}}}
+	roster := &mineproto.QueenRoster{Version: 1, Queens: queens}
+	data, err := mineproto.RosterSigningData(roster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roster.Signature, err = signer.Sign(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		rosterSigner common.Address
+		msg          interface{}
+		source       string // source of the active queen, empty if not active
+	}{
+		{"unsigned list", common.Address{}, &ant_pro.Queens{Queens: queens}, QueenSourceUnsigned},
+		{"unsigned list with a roster key", rosterSigner, &ant_pro.Queens{Queens: queens}, ""},
+		{"signed roster", rosterSigner, roster, QueenSourceRoster},
+	} {
+		m := NewQueenManager([]peer.AddrInfo{{ID: "bootstrap"}}, nil, tc.rosterSigner)
+		if _, ok := tc.msg.(*ant_pro.Queens); ok {
+			m.HandleQueenMessage(context.Background(), "from", tc.msg)
+		} else {
+			m.HandleQueenRosterMessage(context.Background(), "from", tc.msg)
+		}
+		sources := make(map[peer.ID]string)
+		for _, h := range m.Health() {
+			sources[h.ID] = h.Source
+		}
+		if sources["active"] != tc.source {
+			t.Fatalf("%s: active queen listed as %q, expected %q", tc.name, sources["active"], tc.source)
+		}
+		if sources["bootstrap"] != QueenSourceBootstrap {
+			t.Fatalf("%s: bootstrap queen listed as %q", tc.name, sources["bootstrap"])
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package mineservice
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"sort"
+	"time"
+)
+
+const (
+	// healthWeight is the weight of the latest ping in the success rate and
+	// the round-trip time averages.
+	healthWeight = 0.2
+
+	minQueenBackoff = 30 * time.Second
+	maxQueenBackoff = 30 * time.Minute
+
+	maxRecentQueenErrors = 5
+)
+
+const (
+	QueenSourceBootstrap = "bootstrap"
+	QueenSourceRoster    = "roster"
+	QueenSourceUnsigned  = "unsigned" // unsigned queen list
+)
+
+// QueenError is a failed ping to a queen.
+type QueenError struct {
+	Time  time.Time
+	Error string
+}
+
+// QueenHealth is the ping health of a queen.
+type QueenHealth struct {
+	ID                  peer.ID
+	Source              string
+	Addrs               []string
+	Pings               uint64
+	Failures            uint64
+	ConsecutiveFailures int
+	SuccessRate         float64
+	RTT                 time.Duration
+	LastPing            time.Time
+	LastPong            time.Time
+	LastError           string
+	RecentErrors        []QueenError
+	LastReport          time.Time
+	BackoffUntil        time.Time
+}
+
+// Down reports whether the queen is backed off after failed pings.
+func (h *QueenHealth) Down(now time.Time) bool {
+	return now.Before(h.BackoffUntil)
+}
+
+func newQueenHealth(id peer.ID) *QueenHealth {
+	return &QueenHealth{
+		ID:          id,
+		SuccessRate: 1,
+	}
+}
+
+// health returns the health of the queen, the caller must hold the lock.
+func (m *QueenManager) health(id peer.ID) *QueenHealth {
+	h, ok := m.healths[id]
+	if !ok {
+		h = newQueenHealth(id)
+		m.healths[id] = h
+	}
+	return h
+}
+
+// ReportPing records the outcome of a ping to the queen. Failing queens are
+// backed off exponentially, a successful ping ends the back-off.
+func (m *QueenManager) ReportPing(id peer.ID, rtt time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	h := m.health(id)
+	h.Pings++
+	h.LastPing = now
+	if err != nil {
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.SuccessRate = (1 - healthWeight) * h.SuccessRate
+		h.LastError = err.Error()
+		h.RecentErrors = append(h.RecentErrors, QueenError{Time: now, Error: h.LastError})
+		if len(h.RecentErrors) > maxRecentQueenErrors {
+			h.RecentErrors = h.RecentErrors[len(h.RecentErrors)-maxRecentQueenErrors:]
+		}
+		backoff := minQueenBackoff << uint(h.ConsecutiveFailures-1)
+		if backoff > maxQueenBackoff || backoff <= 0 {
+			backoff = maxQueenBackoff
+		}
+		h.BackoffUntil = now.Add(backoff)
+		queenPingFailures.WithLabelValues(id.String()).Inc()
+	} else {
+		h.ConsecutiveFailures = 0
+		h.SuccessRate = (1-healthWeight)*h.SuccessRate + healthWeight
+		if h.RTT == 0 {
+			h.RTT = rtt
+		} else {
+			h.RTT = time.Duration((1-healthWeight)*float64(h.RTT) + healthWeight*float64(rtt))
+		}
+		h.LastPong = now
+		h.BackoffUntil = time.Time{}
+		queenRTT.WithLabelValues(id.String()).Set(h.RTT.Seconds())
+		queenPingDuration.WithLabelValues(id.String()).Observe(rtt.Seconds())
+	}
+	queenSuccessRate.WithLabelValues(id.String()).Set(h.SuccessRate)
+	down := 0.0
+	if h.Down(now) {
+		down = 1
+	}
+	queenDown.WithLabelValues(id.String()).Set(down)
+}
+
+// ReportSent records that a report was sent to the queen.
+func (m *QueenManager) ReportSent(id peer.ID) {
+	m.Lock()
+	defer m.Unlock()
+	m.lastReported = id
+	m.health(id).LastReport = time.Now()
+	queenReports.WithLabelValues(id.String()).Inc()
+}
+
+// LastReported returns the queen the last report was sent to.
+func (m *QueenManager) LastReported() peer.ID {
+	m.RLock()
+	defer m.RUnlock()
+	return m.lastReported
+}
+
+// Queen returns the known queen with the given ID.
+func (m *QueenManager) Queen(id peer.ID) (peer.AddrInfo, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, queens := range [][]peer.AddrInfo{m.activeQueens, m.bootstrapQueens} {
+		for _, q := range queens {
+			if q.ID == id {
+				return q, true
+			}
+		}
+	}
+	return peer.AddrInfo{}, false
+}
+
+// QueensToPing returns the active queens whose back-off has expired. The
+// bootstrap queens are only pinged when every active queen is down.
+func (m *QueenManager) QueensToPing() []peer.AddrInfo {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := time.Now()
+	ret := m.up(m.activeQueens, now)
+	if len(ret) == 0 {
+		ret = m.up(m.bootstrapQueens, now)
+	}
+	return ret
+}
+
+// Pinged returns the queens which are pinged: the active queens, and the
+// bootstrap queens while every active queen is down.
+func (m *QueenManager) Pinged() map[peer.ID]bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	ret := make(map[peer.ID]bool)
+	for _, q := range m.activeQueens {
+		ret[q.ID] = true
+	}
+	if len(m.up(m.activeQueens, time.Now())) == 0 {
+		for _, q := range m.bootstrapQueens {
+			ret[q.ID] = true
+		}
+	}
+	return ret
+}
+
+// up returns the queens which are not down, the caller must hold the lock.
+func (m *QueenManager) up(queens []peer.AddrInfo, now time.Time) []peer.AddrInfo {
+	var ret []peer.AddrInfo
+	for _, q := range queens {
+		if h, ok := m.healths[q.ID]; ok && h.Down(now) {
+			continue
+		}
+		ret = append(ret, q)
+	}
+	return ret
+}
+
+// healthiest returns the queens which are not down, the healthiest first.
+// The caller must hold the lock.
+func (m *QueenManager) healthiest(queens []peer.AddrInfo, now time.Time) []peer.AddrInfo {
+	up := m.up(queens, now)
+	sort.SliceStable(up, func(i, j int) bool {
+		hi, hj := m.healths[up[i].ID], m.healths[up[j].ID]
+		if hi == nil || hj == nil {
+			// queens which were never pinged come last
+			return hi != nil && hj == nil
+		}
+		if hi.SuccessRate != hj.SuccessRate {
+			return hi.SuccessRate > hj.SuccessRate
+		}
+		return hi.RTT < hj.RTT
+	})
+	return up
+}
+
+// Health returns the health of the active and bootstrap queens.
+func (m *QueenManager) Health() []QueenHealth {
+	m.RLock()
+	defer m.RUnlock()
+
+	var ret []QueenHealth
+	seen := make(map[peer.ID]struct{})
+	add := func(queens []peer.AddrInfo, source string) {
+		for _, q := range queens {
+			if _, ok := seen[q.ID]; ok {
+				continue
+			}
+			seen[q.ID] = struct{}{}
+			h := newQueenHealth(q.ID)
+			if v, ok := m.healths[q.ID]; ok {
+				h = v
+			}
+			entry := *h
+			entry.Source = source
+			entry.Addrs = nil
+			for _, addr := range q.Addrs {
+				entry.Addrs = append(entry.Addrs, addr.String())
+			}
+			entry.RecentErrors = append([]QueenError(nil), h.RecentErrors...)
+			ret = append(ret, entry)
+		}
+	}
+	add(m.activeQueens, m.activeSource)
+	add(m.bootstrapQueens, QueenSourceBootstrap)
+	return ret
+}
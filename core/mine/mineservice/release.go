@@ -0,0 +1,165 @@
+package mineservice
+
+import (
+	"context"
+	"errors"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	proto "github.com/antnest-network/ant-proto"
+)
+
+const (
+	maxReleaseBlocks = 4096
+)
+
+var (
+	ErrBlockNotStored = errors.New("block is not stored for the queens")
+)
+
+// HandleReleaseBlocksMessage unpins the blocks the queen no longer needs on
+// this ant and removes them from the block index, the garbage collection of
+// the repo reclaims their space. Blocks which are not in the block index are
+// not released, whatever their pins. Releasing the root of a DAG migrated as
+// a unit releases the whole DAG.
+func (m *MineService) HandleReleaseBlocksMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	req, ok := msg.(*mineproto.ReleaseBlocksReq)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	log.Infof("received release message from %v for %v blocks", from, len(req.Cids))
+	if len(req.Cids) > maxReleaseBlocks {
+		log.Warnf("refused release message from %v: %v blocks, at most %v allowed", from,
+			len(req.Cids), maxReleaseBlocks)
+		return
+	}
+
+	resp := &mineproto.ReleaseBlocksResp{
+		Seq: req.Seq,
+	}
+	released := 0
+	for _, c := range req.Cids {
+		result := &mineproto.ReleaseBlocksResp_Result{
+			Cid:  c,
+			Code: proto.Success,
+		}
+		code, err := m.releaseBlock(ctx, c)
+		if err != nil {
+			result.Code = code
+			result.ErrString = err.Error()
+		} else {
+			released++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	if released > 0 {
+		if err := m.pinning.Flush(ctx); err != nil {
+			log.Errorf("failed to flush pins: %v", err)
+		}
+	}
+	log.Infof("released %v of %v blocks for %v", released, len(req.Cids), from)
+
+	err := m.sendMessage(ctx, mineproto.ProtocolReleaseBlocksResp, from, resp)
+	if err != nil {
+		log.Errorf("failed to respond to release message: %v", err)
+		return
+	}
+}
+
+func (m *MineService) releaseBlock(ctx context.Context, c string) (int32, error) {
+	bcid, err := cid.Decode(c)
+	if err != nil {
+		return mineproto.CodeInvalidCid, err
+	}
+	record, indexed, err := m.blockIndex.Has(bcid)
+	if err != nil {
+		return proto.Failure, err
+	}
+	// only the blocks stored for the queens are released, the pins of the
+	// operator are left alone
+	if !indexed {
+		return mineproto.CodeNotStored, ErrBlockNotStored
+	}
+	// the blocks of a DAG pinned directly are released with its root
+	blocks, err := m.blockIndex.DagBlocks(bcid)
+	if err != nil {
+		return proto.Failure, err
+	}
+	if len(blocks) > 0 {
+		for _, b := range blocks {
+			if err := m.releaseDagBlock(ctx, bcid, b); err != nil {
+				return proto.Failure, err
+			}
+		}
+		return proto.Success, nil
+	}
+	if err := m.unstoreBlock(ctx, bcid, record); err != nil {
+		return proto.Failure, err
+	}
+	return proto.Success, nil
+}
+
+// releaseDagBlock removes the block from the DAG of the root. The block is
+// unstored once no other DAG pinned directly lists it, unless it was stored
+// before the DAG and keeps its own record.
+func (m *MineService) releaseDagBlock(ctx context.Context, root, bcid cid.Cid) error {
+	if err := m.blockIndex.RemoveDagBlock(root, bcid); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	record, indexed, err := m.blockIndex.Has(bcid)
+	if err != nil {
+		return err
+	}
+	if !indexed || record.Origin != BlockOriginDagMigration || record.Root == "" {
+		return nil
+	}
+	roots, err := m.blockIndex.DagRoots(bcid)
+	if err != nil {
+		return err
+	}
+	if len(roots) > 0 {
+		if record.Root == root.String() {
+			return m.blockIndex.SetRoot(bcid, roots[0])
+		}
+		return nil
+	}
+	return m.unstoreBlock(ctx, bcid, record)
+}
+
+// unstoreBlock unpins an indexed block, removes it from the block index and
+// from the DAGs pinned directly it belongs to.
+func (m *MineService) unstoreBlock(ctx context.Context, bcid cid.Cid, record *BlockRecord) error {
+	// the root of a DAG migrated as a unit may be pinned recursively
+	mode := pin.Direct
+	recursive := record.Origin == BlockOriginDagMigration && record.Root == ""
+	if recursive {
+		mode = pin.Any
+	}
+	how, pinned, err := m.pinning.IsPinnedWithType(ctx, bcid, mode)
+	if err != nil {
+		return err
+	}
+	// an indirect pin is reported with the cid of its recursive pin
+	pinned = pinned && (how == "recursive" || how == "direct")
+	if pinned {
+		if err := m.pinning.Unpin(ctx, bcid, recursive); err != nil {
+			return err
+		}
+	}
+	if err := m.blockIndex.Remove(bcid); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	roots, err := m.blockIndex.DagRoots(bcid)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := m.blockIndex.RemoveDagBlock(root, bcid); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	return m.scrubber.release(bcid)
+}
@@ -0,0 +1,231 @@
+package mineservice
+
+import (
+	"context"
+	"testing"
+
+	proto "github.com/antnest-network/ant-proto"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	dstest "github.com/ipfs/go-merkledag/test"
+	"github.com/multiformats/go-multihash"
+)
+
+func newTestReleaseService(t *testing.T) *MineService {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	pinning, err := dspinner.New(ctx, dstore, dstest.Mock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateStore := statestore.NewStore(dstore)
+	return &MineService{
+		pinning:    pinning,
+		blockIndex: NewBlockIndex(stateStore),
+		scrubber:   &scrubber{stateStore: stateStore},
+	}
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	hash, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, hash)
+}
+
+// addTestDag indexes and pins directly the blocks of a DAG as a migration
+// does, the root included.
+func addTestDag(t *testing.T, m *MineService, root cid.Cid, blocks ...cid.Cid) {
+	for _, bcid := range append([]cid.Cid{root}, blocks...) {
+		m.pinning.PinWithMode(bcid, pin.Direct)
+		if err := m.blockIndex.AddDagBlock(bcid, 1, "ant", root); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func isPinned(t *testing.T, m *MineService, bcid cid.Cid) bool {
+	_, pinned, err := m.pinning.IsPinnedWithType(context.Background(), bcid, pin.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pinned
+}
+
+func isIndexed(t *testing.T, m *MineService, bcid cid.Cid) bool {
+	_, indexed, err := m.blockIndex.Has(bcid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexed
+}
+
+func TestReleaseSharedDagBlock(t *testing.T) {
+	ctx := context.Background()
+	rootA, rootB := testCid(t, "root a"), testCid(t, "root b")
+	onlyA, onlyB, shared := testCid(t, "a"), testCid(t, "b"), testCid(t, "shared")
+
+	for _, tc := range []struct {
+		name          string
+		first, second cid.Cid
+	}{
+		{"first dag first", rootA, rootB},
+		{"second dag first", rootB, rootA},
+	} {
+		m := newTestReleaseService(t)
+		addTestDag(t, m, rootA, onlyA, shared)
+		addTestDag(t, m, rootB, onlyB, shared)
+
+		code, err := m.releaseBlock(ctx, tc.first.String())
+		if err != nil || code != proto.Success {
+			t.Fatalf("%s: release of %v returned %d, %v", tc.name, tc.first, code, err)
+		}
+		if !isPinned(t, m, shared) || !isIndexed(t, m, shared) {
+			t.Fatalf("%s: shared block was unstored with the first dag", tc.name)
+		}
+		record, err := m.blockIndex.Get(shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Root != tc.second.String() {
+			t.Fatalf("%s: shared block refers to %v, expected %v", tc.name, record.Root, tc.second)
+		}
+		blocks, err := m.blockIndex.DagBlocks(tc.first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 0 {
+			t.Fatalf("%s: %d blocks left in the released dag", tc.name, len(blocks))
+		}
+
+		code, err = m.releaseBlock(ctx, tc.second.String())
+		if err != nil || code != proto.Success {
+			t.Fatalf("%s: release of %v returned %d, %v", tc.name, tc.second, code, err)
+		}
+		for _, bcid := range []cid.Cid{rootA, rootB, onlyA, onlyB, shared} {
+			if isPinned(t, m, bcid) || isIndexed(t, m, bcid) {
+				t.Fatalf("%s: block %v is still stored", tc.name, bcid)
+			}
+		}
+		roots, err := m.blockIndex.DagRoots(shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roots) != 0 {
+			t.Fatalf("%s: shared block still belongs to %v", tc.name, roots)
+		}
+	}
+}
+
+func TestReleaseKeepsBlockStoredBeforeDag(t *testing.T) {
+	ctx := context.Background()
+	m := newTestReleaseService(t)
+	root, pushed := testCid(t, "root"), testCid(t, "pushed")
+
+	m.pinning.PinWithMode(pushed, pin.Direct)
+	if err := m.blockIndex.Add(pushed, 1, "queen", BlockOriginPush); err != nil {
+		t.Fatal(err)
+	}
+	addTestDag(t, m, root, pushed)
+
+	code, err := m.releaseBlock(ctx, root.String())
+	if err != nil || code != proto.Success {
+		t.Fatalf("release returned %d, %v", code, err)
+	}
+	if isIndexed(t, m, root) || isPinned(t, m, root) {
+		t.Fatal("root is still stored")
+	}
+	if !isIndexed(t, m, pushed) || !isPinned(t, m, pushed) {
+		t.Fatal("pushed block was unstored with the dag")
+	}
+}
+
+func TestReleaseBlock(t *testing.T) {
+	ctx := context.Background()
+	bcid := testCid(t, "block")
+
+	for _, tc := range []struct {
+		name   string
+		setup  func(m *MineService)
+		cid    string
+		code   int32
+		pinned bool // whether the block stays pinned
+	}{{
+		name: "invalid cid",
+		cid:  "block",
+		code: mineproto.CodeInvalidCid,
+	}, {
+		name: "pinned by the operator",
+		setup: func(m *MineService) {
+			m.pinning.PinWithMode(bcid, pin.Direct)
+		},
+		code:   mineproto.CodeNotStored,
+		pinned: true,
+	}, {
+		name: "pushed block",
+		setup: func(m *MineService) {
+			m.pinning.PinWithMode(bcid, pin.Direct)
+			if err := m.blockIndex.Add(bcid, 1, "queen", BlockOriginPush); err != nil {
+				t.Fatal(err)
+			}
+		},
+		code: proto.Success,
+	}, {
+		name: "root of a dag pinned recursively",
+		setup: func(m *MineService) {
+			m.pinning.PinWithMode(bcid, pin.Recursive)
+			if err := m.blockIndex.Add(bcid, 1, "ant", BlockOriginDagMigration); err != nil {
+				t.Fatal(err)
+			}
+		},
+		code: proto.Success,
+	}, {
+		name: "quarantined block",
+		setup: func(m *MineService) {
+			if err := m.blockIndex.Add(bcid, 1, "queen", BlockOriginPush); err != nil {
+				t.Fatal(err)
+			}
+			record := &QuarantineRecord{Cid: bcid.String(), Reason: ScrubReasonMissing}
+			if err := m.scrubber.stateStore.Put(QuarantineKey(bcid), record); err != nil {
+				t.Fatal(err)
+			}
+		},
+		code: proto.Success,
+	}} {
+		m := newTestReleaseService(t)
+		if tc.setup != nil {
+			tc.setup(m)
+		}
+		c := tc.cid
+		if c == "" {
+			c = bcid.String()
+		}
+		code, err := m.releaseBlock(ctx, c)
+		if code != tc.code || (err == nil) != (tc.code == proto.Success) {
+			t.Fatalf("%s: release returned %d, %v, expected %d", tc.name, code, err, tc.code)
+		}
+		_, pinned, err := m.pinning.IsPinned(ctx, bcid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned != tc.pinned {
+			t.Fatalf("%s: block pinned: %v", tc.name, pinned)
+		}
+		if tc.code == proto.Success && isIndexed(t, m, bcid) {
+			t.Fatalf("%s: block still indexed", tc.name)
+		}
+		quarantine, err := m.scrubber.Quarantine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(quarantine) != 0 {
+			t.Fatalf("%s: block still quarantined", tc.name)
+		}
+	}
+}
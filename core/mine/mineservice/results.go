@@ -0,0 +1,399 @@
+package mineservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ant_pro "github.com/antnest-network/ant-proto/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/mr-tron/base58/base58"
+	"sync"
+	"time"
+)
+
+const (
+	resultBatchPrefix = "/migration/result/"
+
+	resultAckTimeout  = 30 * time.Second
+	minResultBackoff  = 10 * time.Second
+	maxResultBackoff  = 10 * time.Minute
+	defaultResultWait = time.Minute
+)
+
+var (
+	ErrResultsNotAcked = errors.New("migration results not acknowledged by the queen")
+)
+
+// ResultBatch is a batch of migration results of blocks from the same ant.
+// A batch is open until it is full or FlushInterval old, it is then sealed
+// and sent until a queen acknowledges its id. The result of a DAG is sent on
+// its own in a sealed batch. FromAnt is kept in its text form, the messages
+// carry the bytes of the peer id like the other ant-proto messages.
+type ResultBatch struct {
+	Id          uint64
+	FromAnt     string
+	Blocks      []*ant_pro.MigrateBlockResult_Block
+	Dag         *DagResult `json:",omitempty"`
+	Created     int64
+	Sealed      bool
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+}
+
+// DagResult is the outcome of the migration of a DAG.
+type DagResult struct {
+	Root   string
+	Blocks uint64
+	Bytes  uint64
+	Code   int32
+}
+
+// size returns the number of results in the batch.
+func (b *ResultBatch) size() int {
+	if b.Dag != nil {
+		return 1
+	}
+	return len(b.Blocks)
+}
+
+func ResultBatchKey(id uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%020d", resultBatchPrefix, id))
+}
+
+// pendingAck is a batch sent to a queen and waiting for its acknowledgement.
+type pendingAck struct {
+	queen peer.ID
+	acked chan struct{}
+}
+
+type resultSender func(ctx context.Context, batch *ResultBatch) error
+
+// resultReporter groups the migration results by source ant and reports them
+// in batches. The batches are kept in the state store until acknowledged,
+// so that every result reaches the queens at least once, across restarts.
+type resultReporter struct {
+	stateStore    statestore.StateStore
+	send          resultSender
+	batchSize     int
+	flushInterval time.Duration
+
+	lock    sync.Mutex
+	batches map[uint64]*ResultBatch
+	open    map[string]*ResultBatch // source ant -> open batch
+	lastId  uint64
+	acks    map[uint64]pendingAck
+	wake    chan struct{}
+}
+
+func newResultReporter(stateStore statestore.StateStore, send resultSender, batchSize int,
+	flushInterval time.Duration) *resultReporter {
+	return &resultReporter{
+		stateStore:    stateStore,
+		send:          send,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batches:       make(map[uint64]*ResultBatch),
+		open:          make(map[string]*ResultBatch),
+		acks:          make(map[uint64]pendingAck),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (r *resultReporter) load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	defer func() {
+		migrationResultsPending.Set(float64(r.pending()))
+	}()
+	return r.stateStore.Iterate(resultBatchPrefix, func(key string, value []byte) (stop bool, err error) {
+		batch := ResultBatch{}
+		if err := json.Unmarshal(value, &batch); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		r.batches[batch.Id] = &batch
+		if !batch.Sealed {
+			r.open[batch.FromAnt] = &batch
+		}
+		if batch.Id > r.lastId {
+			r.lastId = batch.Id
+		}
+		return false, nil
+	})
+}
+
+// nextId returns an id greater than any id given before, ids stay unique
+// across restarts as they follow the clock.
+func (r *resultReporter) nextId() uint64 {
+	id := uint64(time.Now().UnixNano())
+	if id <= r.lastId {
+		id = r.lastId + 1
+	}
+	r.lastId = id
+	return id
+}
+
+// Add adds the result to the open batch of the ant, the batch is sealed once
+// full.
+func (r *resultReporter) Add(ant peer.ID, bcid cid.Cid, code int) error {
+	return r.add(ant, bcid.String(), code)
+}
+
+func (r *resultReporter) add(ant peer.ID, c string, code int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	batch, ok := r.open[ant.String()]
+	if !ok {
+		batch = &ResultBatch{
+			Id:      r.nextId(),
+			FromAnt: ant.String(),
+			Created: time.Now().UnixNano(),
+		}
+		r.open[batch.FromAnt] = batch
+		r.batches[batch.Id] = batch
+	}
+	batch.Blocks = append(batch.Blocks, &ant_pro.MigrateBlockResult_Block{
+		Cid:  c,
+		Code: int32(code),
+	})
+	migrationResultsPending.Inc()
+	if len(batch.Blocks) >= r.batchSize {
+		r.seal(batch)
+		r.notify()
+	}
+	return r.stateStore.Put(ResultBatchKey(batch.Id), batch)
+}
+
+// AddDag queues the result of the migration of a DAG.
+func (r *resultReporter) AddDag(ant peer.ID, result *DagResult) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	batch := &ResultBatch{
+		Id:      r.nextId(),
+		FromAnt: ant.String(),
+		Dag:     result,
+		Created: time.Now().UnixNano(),
+	}
+	batch.Sealed = true
+	batch.NextAttempt = batch.Created
+	if err := r.stateStore.Put(ResultBatchKey(batch.Id), batch); err != nil {
+		return err
+	}
+	r.batches[batch.Id] = batch
+	migrationResultsPending.Inc()
+	r.notify()
+	return nil
+}
+
+func (r *resultReporter) seal(batch *ResultBatch) {
+	batch.Sealed = true
+	batch.NextAttempt = time.Now().UnixNano()
+	delete(r.open, batch.FromAnt)
+}
+
+func (r *resultReporter) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the number of results not acknowledged yet.
+func (r *resultReporter) Pending() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.pending()
+}
+
+func (r *resultReporter) pending() int {
+	n := 0
+	for _, batch := range r.batches {
+		n += batch.size()
+	}
+	return n
+}
+
+// run sends the batches which are due, one at a time, until ctx is done.
+func (r *resultReporter) run(ctx context.Context) {
+	for {
+		batch, wait := r.next()
+		if batch != nil {
+			r.deliver(ctx, batch)
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.wake:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// next seals the open batches older than the flush interval and returns the
+// oldest sealed batch which is due, or how long to wait for one.
+func (r *resultReporter) next() (*ResultBatch, time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, batch := range r.open {
+		if now-batch.Created >= int64(r.flushInterval) {
+			r.seal(batch)
+			if err := r.stateStore.Put(ResultBatchKey(batch.Id), batch); err != nil {
+				log.Errorf("failed to save migration results %v: %v", batch.Id, err)
+			}
+		}
+	}
+
+	var due *ResultBatch
+	wait := defaultResultWait
+	for _, batch := range r.batches {
+		if !batch.Sealed {
+			if d := time.Duration(batch.Created + int64(r.flushInterval) - now); d < wait {
+				wait = d
+			}
+			continue
+		}
+		if batch.NextAttempt <= now {
+			if due == nil || batch.Id < due.Id {
+				due = batch
+			}
+			continue
+		}
+		if d := time.Duration(batch.NextAttempt - now); d < wait {
+			wait = d
+		}
+	}
+	return due, wait
+}
+
+func (r *resultReporter) deliver(ctx context.Context, batch *ResultBatch) {
+	err := r.send(ctx, batch)
+	if ctx.Err() != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil {
+		delete(r.batches, batch.Id)
+		migrationResultsPending.Sub(float64(batch.size()))
+		if err := r.stateStore.Delete(ResultBatchKey(batch.Id)); err != nil {
+			log.Errorf("failed to remove migration results %v: %v", batch.Id, err)
+		}
+		log.Infof("reported %v migration results from %v", batch.size(), batch.FromAnt)
+		return
+	}
+	batch.Attempts++
+	batch.LastError = err.Error()
+	backoff := minResultBackoff << uint(batch.Attempts-1)
+	if backoff > maxResultBackoff || backoff <= 0 {
+		backoff = maxResultBackoff
+	}
+	batch.NextAttempt = time.Now().Add(backoff).UnixNano()
+	if err := r.stateStore.Put(ResultBatchKey(batch.Id), batch); err != nil {
+		log.Errorf("failed to save migration results %v: %v", batch.Id, err)
+	}
+	log.Warnf("failed to report migration results %v, attempt %v, retrying in %v: %v",
+		batch.Id, batch.Attempts, backoff, err)
+}
+
+// expectAck returns a channel closed when the queen acknowledges the batch.
+func (r *resultReporter) expectAck(id uint64, queen peer.ID) chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ch := make(chan struct{})
+	r.acks[id] = pendingAck{queen: queen, acked: ch}
+	return ch
+}
+
+func (r *resultReporter) forgetAck(id uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.acks, id)
+}
+
+// ack acknowledges the batch, only the queen it was sent to can acknowledge
+// it.
+func (r *resultReporter) ack(from peer.ID, id uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pending, ok := r.acks[id]
+	if !ok || pending.queen != from {
+		return false
+	}
+	close(pending.acked)
+	delete(r.acks, id)
+	return true
+}
+
+// HandleMigrateBlockResultsAckMessage handles the acknowledgement of a batch
+// of migration results.
+func (m *MineService) HandleMigrateBlockResultsAckMessage(ctx context.Context, from peer.ID, msg interface{}) {
+	ack, ok := msg.(*mineproto.MigrateBlockResultsAck)
+	if !ok {
+		log.Infof("msg type error: %v, %+v", from, msg)
+		return
+	}
+	if !m.results.ack(from, ack.Id) {
+		log.Warnf("unexpected ack of migration results %v from %v", ack.Id, from)
+	}
+}
+
+// sendResultBatch sends the batch to a queen and waits for its
+// acknowledgement. A batch is sent again when the acknowledgement is lost or
+// comes late, the queen drops the batches whose id it has seen already.
+func (m *MineService) sendResultBatch(ctx context.Context, batch *ResultBatch) error {
+	// the ant of a failed migration may not be a valid peer id
+	raw, err := base58.Decode(batch.FromAnt)
+	if err != nil {
+		return err
+	}
+	ant := peer.ID(raw)
+	to := m.queenManager.GetQueen()
+	acked := m.results.expectAck(batch.Id, to.ID)
+	defer m.results.forgetAck(batch.Id)
+
+	if batch.Dag != nil {
+		err = m.sendMessage(ctx, mineproto.ProtocolMigrateDagProgress, to.ID, &mineproto.MigrateDagProgress{
+			FromAnt: string(ant),
+			Root:    batch.Dag.Root,
+			Blocks:  batch.Dag.Blocks,
+			Bytes:   batch.Dag.Bytes,
+			Done:    true,
+			Code:    batch.Dag.Code,
+			Id:      batch.Id,
+		})
+	} else {
+		err = m.sendMessage(ctx, mineproto.ProtocolMigrateBlockResults, to.ID, &mineproto.MigrateBlockResults{
+			Id:      batch.Id,
+			FromAnt: string(ant),
+			Blocks:  batch.Blocks,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(resultAckTimeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		m.queenManager.ReportSent(to.ID)
+		return nil
+	case <-timer.C:
+		return ErrResultsNotAcked
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,133 @@
+package mineservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	proto "github.com/antnest-network/ant-proto"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestResultAck(t *testing.T) {
+	queen, other := peer.ID("queen"), peer.ID("other")
+
+	for _, tc := range []struct {
+		name  string
+		from  peer.ID
+		id    uint64
+		acked bool
+	}{
+		{"queen sent to", queen, 1, true},
+		{"another queen", other, 1, false},
+		{"unknown batch", queen, 2, false},
+	} {
+		r := newResultReporter(nil, nil, 1, time.Hour)
+		ch := r.expectAck(1, queen)
+		if got := r.ack(tc.from, tc.id); got != tc.acked {
+			t.Fatalf("%s: ack returned %v", tc.name, got)
+		}
+		select {
+		case <-ch:
+			if !tc.acked {
+				t.Fatalf("%s: batch acknowledged", tc.name)
+			}
+			if r.ack(tc.from, tc.id) {
+				t.Fatalf("%s: batch acknowledged twice", tc.name)
+			}
+		default:
+			if tc.acked {
+				t.Fatalf("%s: batch not acknowledged", tc.name)
+			}
+		}
+	}
+}
+
+func TestResultBatches(t *testing.T) {
+	ctx := context.Background()
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	antA, antB := peer.ID("ant a"), peer.ID("ant b")
+	sendErr := errors.New("queen unreachable")
+	var sent []*ResultBatch
+	failing := true
+	r := newResultReporter(stateStore, func(ctx context.Context, batch *ResultBatch) error {
+		sent = append(sent, batch)
+		if failing {
+			return sendErr
+		}
+		return nil
+	}, 2, time.Hour)
+
+	for _, add := range []struct {
+		ant peer.ID
+		cid string
+	}{{antA, "a1"}, {antB, "b1"}, {antA, "a2"}, {antA, "a3"}} {
+		if err := r.add(add.ant, add.cid, proto.Success); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.AddDag(antA, &DagResult{Root: "root", Blocks: 3, Bytes: 30, Code: proto.Success}); err != nil {
+		t.Fatal(err)
+	}
+	openA := r.open[antA.String()]
+	if pending := r.Pending(); pending != 5 {
+		t.Fatalf("%d results pending, expected 5", pending)
+	}
+
+	// the full batch of ant a is due first, then the dag result, the open
+	// batches wait for the flush interval
+	full, _ := r.next()
+	if full == nil || full.FromAnt != antA.String() || len(full.Blocks) != 2 {
+		t.Fatalf("unexpected first batch %+v", full)
+	}
+	r.deliver(ctx, full)
+	if full.Attempts != 1 || full.LastError != sendErr.Error() || full.NextAttempt <= time.Now().UnixNano() {
+		t.Fatalf("failed batch not backed off: %+v", full)
+	}
+	dag, wait := r.next()
+	if dag == nil || dag.Dag == nil || dag.Dag.Root != "root" {
+		t.Fatalf("unexpected second batch %+v", dag)
+	}
+	failing = false
+	r.deliver(ctx, dag)
+	if batch, _ := r.next(); batch != nil {
+		t.Fatalf("unexpected due batch %+v", batch)
+	}
+	if wait > minResultBackoff {
+		t.Fatalf("waiting %v for the failed batch", wait)
+	}
+	if pending := r.Pending(); pending != 4 {
+		t.Fatalf("%d results pending, expected 4", pending)
+	}
+
+	// the batches not acknowledged are restored, the open ones stay open
+	restored := newResultReporter(stateStore, nil, 2, time.Hour)
+	if err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if pending := restored.Pending(); pending != 4 {
+		t.Fatalf("%d results restored, expected 4", pending)
+	}
+	if b := restored.batches[full.Id]; b == nil || !b.Sealed || b.Attempts != 1 {
+		t.Fatalf("failed batch restored as %+v", b)
+	}
+	if _, ok := restored.batches[dag.Id]; ok {
+		t.Fatal("delivered dag result restored")
+	}
+	if err := restored.add(antA, "a4", proto.Success); err != nil {
+		t.Fatal(err)
+	}
+	if b := restored.batches[openA.Id]; b == nil || len(b.Blocks) != 2 || !b.Sealed {
+		t.Fatalf("open batch of ant a restored as %+v", b)
+	}
+	if restored.nextId() <= dag.Id {
+		t.Fatal("batch id reused after restart")
+	}
+	if len(sent) != 2 {
+		t.Fatalf("%d batches sent, expected 2", len(sent))
+	}
+}
@@ -0,0 +1,125 @@
+package mineservice
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/crypto"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"time"
+)
+
+const (
+	queenRosterKey = "/queen/roster"
+)
+
+var (
+	ErrRosterSignerNotConfigured = errors.New("roster signer not configured")
+	ErrInvalidRosterSignature    = errors.New("invalid roster signature")
+	ErrRosterSignerMismatch      = errors.New("roster not signed by the roster key")
+	ErrRosterNotNewer            = errors.New("roster version is not above the current roster")
+	ErrNoQueenRoster             = errors.New("no queen roster received yet")
+)
+
+// RosterRecord is the latest valid queen roster.
+type RosterRecord struct {
+	Roster     *mineproto.QueenRoster
+	Signer     string
+	From       string
+	ReceivedAt int64
+}
+
+// verifyRoster checks that the roster was signed by the roster key and
+// returns the signer address.
+func verifyRoster(roster *mineproto.QueenRoster, rosterSigner common.Address) (common.Address, error) {
+	data, err := mineproto.RosterSigningData(roster)
+	if err != nil {
+		return common.Address{}, err
+	}
+	pubKey, err := crypto.Recover(roster.Signature, data)
+	if err != nil {
+		return common.Address{}, ErrInvalidRosterSignature
+	}
+	signer, err := crypto.NewEthereumAddress(*pubKey)
+	if err != nil {
+		return common.Address{}, ErrInvalidRosterSignature
+	}
+	address := common.BytesToAddress(signer)
+	if address != rosterSigner {
+		return address, ErrRosterSignerMismatch
+	}
+	return address, nil
+}
+
+// loadRoster restores the persisted roster, it is ignored if it was not
+// signed by the configured roster key.
+func (m *QueenManager) loadRoster() {
+	if m.stateStore == nil || m.rosterSigner == (common.Address{}) {
+		return
+	}
+	record := &RosterRecord{}
+	err := m.stateStore.Get(datastore.NewKey(queenRosterKey), record)
+	if err != nil {
+		if err != datastore.ErrNotFound {
+			log.Errorf("failed to load queen roster: %v", err)
+		}
+		return
+	}
+	if _, err := verifyRoster(record.Roster, m.rosterSigner); err != nil {
+		log.Warnf("ignoring persisted queen roster version %v: %v", record.Roster.Version, err)
+		return
+	}
+	m.roster = record
+	m.activeQueens = pbQueens2Addresses(record.Roster.Queens)
+	m.activeSource = QueenSourceRoster
+	log.Infof("loaded queen roster version %v with %v queens", record.Roster.Version, len(m.activeQueens))
+}
+
+// UpdateRoster replaces the active queens with the roster if it is signed by
+// the roster key and newer than the current one, and persists it.
+func (m *QueenManager) UpdateRoster(roster *mineproto.QueenRoster, from peer.ID) error {
+	if m.rosterSigner == (common.Address{}) {
+		return ErrRosterSignerNotConfigured
+	}
+	signer, err := verifyRoster(roster, m.rosterSigner)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.roster != nil && roster.Version <= m.roster.Roster.Version {
+		return ErrRosterNotNewer
+	}
+	record := &RosterRecord{
+		Roster:     roster,
+		Signer:     signer.Hex(),
+		From:       from.String(),
+		ReceivedAt: time.Now().Unix(),
+	}
+	if m.stateStore != nil {
+		if err := m.stateStore.Put(datastore.NewKey(queenRosterKey), record); err != nil {
+			return err
+		}
+	}
+	m.roster = record
+	m.activeQueens = pbQueens2Addresses(roster.Queens)
+	m.activeSource = QueenSourceRoster
+	return nil
+}
+
+// Roster returns the latest valid queen roster.
+func (m *QueenManager) Roster() (*RosterRecord, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.roster == nil {
+		return nil, ErrNoQueenRoster
+	}
+	return m.roster, nil
+}
+
+// RosterSigner returns the address of the configured roster key.
+func (m *QueenManager) RosterSigner() common.Address {
+	return m.rosterSigner
+}
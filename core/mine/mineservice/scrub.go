@@ -0,0 +1,388 @@
+package mineservice
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"time"
+)
+
+const (
+	scrubStateKey    = "/mine/scrub"
+	quarantinePrefix = "/mine/quarantine/"
+)
+
+const (
+	ScrubReasonMissing      = "missing"
+	ScrubReasonHashMismatch = "hash mismatch"
+)
+
+// ScrubState is the progress of the scrubber. Cursor is the last block
+// checked by the current pass, the blocks are checked in cid order.
+type ScrubState struct {
+	Cursor        string
+	Pass          uint64
+	PassStarted   int64
+	LastPassEnded int64
+	Checked       uint64
+	Corrupt       uint64
+	Recovered     uint64
+	Lost          uint64
+}
+
+// QuarantineRecord is a block found corrupt or missing which could not be
+// fetched again. It is kept until the queens release the block, or the root
+// of the DAG pinned recursively the block belongs to.
+type QuarantineRecord struct {
+	Cid        string
+	Root       string `json:",omitempty"`
+	Reason     string
+	DetectedAt int64
+	Reported   bool
+}
+
+func QuarantineKey(bcid cid.Cid) datastore.Key {
+	return datastore.NewKey(quarantinePrefix + bcid.String())
+}
+
+// scrubber re-hashes the blocks stored for the queens at a low rate, so that
+// corrupt blocks are fetched again before a retrieval fails on them.
+type scrubber struct {
+	blockService   blockservice.BlockService
+	blockIndex     *BlockIndex
+	stateStore     statestore.StateStore
+	sendMessage    func(ctx context.Context, msg *mineproto.BlocksLost) error
+	interval       time.Duration
+	passInterval   time.Duration
+	refetchTimeout time.Duration
+}
+
+func newScrubber(blockService blockservice.BlockService, blockIndex *BlockIndex, stateStore statestore.StateStore,
+	sendMessage func(ctx context.Context, msg *mineproto.BlocksLost) error, cfg Scrub) *scrubber {
+	blocksPerMinute := cfg.BlocksPerMinute
+	if blocksPerMinute <= 0 {
+		blocksPerMinute = DefaultConfig().Scrub.BlocksPerMinute
+	}
+	return &scrubber{
+		blockService:   blockService,
+		blockIndex:     blockIndex,
+		stateStore:     stateStore,
+		sendMessage:    sendMessage,
+		interval:       time.Minute / time.Duration(blocksPerMinute),
+		passInterval:   parseDuration(cfg.PassInterval, 24*time.Hour),
+		refetchTimeout: parseDuration(cfg.RefetchTimeout, 2*time.Minute),
+	}
+}
+
+func (s *scrubber) state() (*ScrubState, error) {
+	state := &ScrubState{}
+	err := s.stateStore.Get(datastore.NewKey(scrubStateKey), state)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	return state, nil
+}
+
+// run scrubs the indexed blocks until ctx is done. A pass resumes after the
+// last checked block and the next pass starts passInterval after its end.
+func (s *scrubber) run(ctx context.Context) {
+	state, err := s.state()
+	if err != nil {
+		log.Errorf("failed to load scrub state: %v", err)
+		return
+	}
+	for {
+		if state.Cursor == "" && state.LastPassEnded > 0 {
+			next := time.Unix(0, state.LastPassEnded).Add(s.passInterval)
+			if !sleep(ctx, time.Until(next)) {
+				return
+			}
+		}
+		if err := s.pass(ctx, state); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("scrub pass failed: %v", err)
+			if !sleep(ctx, s.passInterval) {
+				return
+			}
+		}
+	}
+}
+
+// pass checks the indexed blocks after the cursor, in cid order, and the
+// blocks of the DAGs pinned recursively under them. Blocks indexed during the
+// pass are checked by the next one.
+func (s *scrubber) pass(ctx context.Context, state *ScrubState) error {
+	cids, err := s.blockIndex.Cids()
+	if err != nil {
+		return err
+	}
+	if state.Cursor == "" {
+		state.Pass++
+		state.PassStarted = time.Now().UnixNano()
+		log.Infof("starting scrub pass %v over %v blocks", state.Pass, len(cids))
+	}
+	s.reportLost(ctx)
+
+	for _, c := range cids {
+		if c <= state.Cursor {
+			continue
+		}
+		if !sleep(ctx, s.interval) {
+			return ctx.Err()
+		}
+		bcid, err := cid.Decode(c)
+		if err == nil {
+			err = s.checkIndexed(ctx, bcid, state)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Errorf("failed to scrub %v: %v", c, err)
+		}
+		state.Cursor = c
+		state.Checked++
+		if err := s.stateStore.Put(datastore.NewKey(scrubStateKey), state); err != nil {
+			return err
+		}
+	}
+
+	state.Cursor = ""
+	state.LastPassEnded = time.Now().UnixNano()
+	log.Infof("finished scrub pass %v: %v corrupt, %v recovered, %v lost in total", state.Pass,
+		state.Corrupt, state.Recovered, state.Lost)
+	return s.stateStore.Put(datastore.NewKey(scrubStateKey), state)
+}
+
+// checkIndexed checks the indexed block and, if it is the root of a DAG
+// pinned recursively, the blocks of the DAG. The blocks of the DAG are
+// checked at the same rate as the indexed ones.
+func (s *scrubber) checkIndexed(ctx context.Context, bcid cid.Cid, state *ScrubState) error {
+	record, indexed, err := s.blockIndex.Has(bcid)
+	if err != nil {
+		return err
+	}
+	if !indexed || record.Origin != BlockOriginDagMigration || record.Root != "" {
+		s.check(ctx, bcid, nil, state)
+		return nil
+	}
+
+	seen := cid.NewSet()
+	seen.Add(bcid)
+	next := []cid.Cid{bcid}
+	for len(next) > 0 {
+		c := next[len(next)-1]
+		next = next[:len(next)-1]
+		root := &bcid
+		if c.Equals(bcid) {
+			root = nil
+		} else {
+			if !sleep(ctx, s.interval) {
+				return ctx.Err()
+			}
+			state.Checked++
+		}
+		if !s.check(ctx, c, root, state) {
+			continue
+		}
+		links, err := s.links(c)
+		if err != nil {
+			log.Errorf("failed to get links of block %v of dag %v: %v", c, bcid, err)
+			continue
+		}
+		for i := len(links) - 1; i >= 0; i-- {
+			if seen.Visit(links[i].Cid) {
+				next = append(next, links[i].Cid)
+			}
+		}
+	}
+	return nil
+}
+
+// links returns the links of the stored block.
+func (s *scrubber) links(bcid cid.Cid) ([]*ipld.Link, error) {
+	block, err := s.blockService.Blockstore().Get(bcid)
+	if err != nil {
+		return nil, err
+	}
+	node, err := ipld.Decode(block)
+	if err != nil {
+		return nil, err
+	}
+	return node.Links(), nil
+}
+
+// check re-hashes the block against its cid and reports whether the block is
+// stored and valid. A corrupt block is removed and fetched again, a block
+// which cannot be fetched is quarantined and reported to the queen. A block
+// already quarantined is skipped, the queens were told it is lost.
+func (s *scrubber) check(ctx context.Context, bcid cid.Cid, root *cid.Cid, state *ScrubState) bool {
+	err := s.stateStore.Get(QuarantineKey(bcid), &QuarantineRecord{})
+	if err == nil {
+		return false
+	}
+	if err != datastore.ErrNotFound {
+		log.Errorf("failed to get quarantine record of %v: %v", bcid, err)
+		return false
+	}
+
+	reason := ""
+	block, err := s.blockService.Blockstore().Get(bcid)
+	switch err {
+	case nil:
+		sum, err := bcid.Prefix().Sum(block.RawData())
+		if err != nil {
+			log.Errorf("failed to hash block %v: %v", bcid, err)
+			return false
+		}
+		if !sum.Equals(bcid) {
+			reason = ScrubReasonHashMismatch
+		}
+	case blockstore.ErrNotFound:
+		reason = ScrubReasonMissing
+	case blockstore.ErrHashMismatch:
+		reason = ScrubReasonHashMismatch
+	default:
+		log.Errorf("failed to read block %v: %v", bcid, err)
+		return false
+	}
+	if reason == "" {
+		scrubbedBlocks.WithLabelValues("ok").Inc()
+		return true
+	}
+
+	log.Warnf("scrub found block %v %v, fetching it again", bcid, reason)
+	state.Corrupt++
+	scrubbedBlocks.WithLabelValues("corrupt").Inc()
+	if reason == ScrubReasonHashMismatch {
+		if err := s.blockService.Blockstore().DeleteBlock(bcid); err != nil {
+			log.Errorf("failed to remove corrupt block %v: %v", bcid, err)
+			return false
+		}
+	}
+	fctx, cancel := context.WithTimeout(ctx, s.refetchTimeout)
+	defer cancel()
+	_, err = s.blockService.GetBlock(fctx, bcid)
+	if err == nil {
+		log.Infof("scrub recovered block %v", bcid)
+		state.Recovered++
+		scrubbedBlocks.WithLabelValues("recovered").Inc()
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	log.Errorf("scrub lost block %v: %v", bcid, err)
+
+	state.Lost++
+	scrubbedBlocks.WithLabelValues("lost").Inc()
+	record := &QuarantineRecord{
+		Cid:        bcid.String(),
+		Reason:     reason,
+		DetectedAt: time.Now().Unix(),
+	}
+	if root != nil {
+		record.Root = root.String()
+	}
+	if err := s.stateStore.Put(QuarantineKey(bcid), record); err != nil {
+		log.Errorf("failed to quarantine block %v: %v", bcid, err)
+		return false
+	}
+	s.reportLost(ctx)
+	return false
+}
+
+// Quarantine returns the blocks found corrupt or missing which could not be
+// fetched again.
+func (s *scrubber) Quarantine() ([]*QuarantineRecord, error) {
+	var list []*QuarantineRecord
+	err := s.stateStore.Iterate(quarantinePrefix, func(key string, value []byte) (stop bool, err error) {
+		record := QuarantineRecord{}
+		if err := json.Unmarshal(value, &record); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &record)
+		return false, nil
+	})
+	return list, err
+}
+
+// release drops the quarantine record of a block released by the queens,
+// and the records of the blocks of the DAG pinned recursively under it.
+func (s *scrubber) release(bcid cid.Cid) error {
+	list, err := s.Quarantine()
+	if err != nil {
+		return err
+	}
+	for _, record := range list {
+		if record.Cid != bcid.String() && record.Root != bcid.String() {
+			continue
+		}
+		c, err := cid.Decode(record.Cid)
+		if err != nil {
+			continue
+		}
+		if err := s.stateStore.Delete(QuarantineKey(c)); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportLost reports the quarantined blocks not reported yet to the queen.
+func (s *scrubber) reportLost(ctx context.Context) {
+	list, err := s.Quarantine()
+	if err != nil {
+		log.Errorf("failed to list quarantined blocks: %v", err)
+		return
+	}
+	msg := &mineproto.BlocksLost{}
+	var unreported []*QuarantineRecord
+	for _, record := range list {
+		if !record.Reported {
+			msg.Cids = append(msg.Cids, record.Cid)
+			unreported = append(unreported, record)
+		}
+	}
+	if len(unreported) == 0 {
+		return
+	}
+	if err := s.sendMessage(ctx, msg); err != nil {
+		log.Errorf("failed to report %v lost blocks: %v", len(unreported), err)
+		return
+	}
+	for _, record := range unreported {
+		record.Reported = true
+		bcid, err := cid.Decode(record.Cid)
+		if err != nil {
+			continue
+		}
+		if err := s.stateStore.Put(QuarantineKey(bcid), record); err != nil {
+			log.Errorf("failed to save quarantine record of %v: %v", record.Cid, err)
+		}
+	}
+}
+
+// sleep waits for d and returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,167 @@
+package mineservice
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"github.com/ipfs/go-merkledag"
+)
+
+type testScrubber struct {
+	*scrubber
+	blockstore blockstore.Blockstore
+	reported   []string
+}
+
+func newTestScrubber(t *testing.T) *testScrubber {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	stateStore := statestore.NewStore(dstore)
+	s := &testScrubber{blockstore: bstore}
+	s.scrubber = &scrubber{
+		blockService: blockservice.New(bstore, offline.Exchange(bstore)),
+		blockIndex:   NewBlockIndex(stateStore),
+		stateStore:   stateStore,
+		sendMessage: func(ctx context.Context, msg *mineproto.BlocksLost) error {
+			s.reported = append(s.reported, msg.Cids...)
+			return nil
+		},
+	}
+	return s
+}
+
+// put stores the node, or data which does not match its cid if corrupt.
+func (s *testScrubber) put(t *testing.T, node blocks.Block, corrupt bool) {
+	block := node
+	if corrupt {
+		var err error
+		block, err = blocks.NewBlockWithCid([]byte("corrupt"), node.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.blockstore.Put(block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *testScrubber) quarantined(t *testing.T) map[string]*QuarantineRecord {
+	list, err := s.Quarantine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]*QuarantineRecord)
+	for _, record := range list {
+		ret[record.Cid] = record
+	}
+	return ret
+}
+
+func TestScrub(t *testing.T) {
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	other := merkledag.NewRawNode([]byte("other"))
+	root := merkledag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("other", other); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, s *testScrubber)
+		lost  map[string]string // cid -> reason
+		roots map[string]string // cid -> root of the quarantine record
+	}{{
+		name: "valid block",
+		setup: func(t *testing.T, s *testScrubber) {
+			s.put(t, leaf, false)
+			s.blockIndex.Add(leaf.Cid(), 4, "queen", BlockOriginPush)
+		},
+	}, {
+		name: "missing block",
+		setup: func(t *testing.T, s *testScrubber) {
+			s.blockIndex.Add(leaf.Cid(), 4, "queen", BlockOriginPush)
+		},
+		lost: map[string]string{leaf.Cid().String(): ScrubReasonMissing},
+	}, {
+		name: "corrupt block",
+		setup: func(t *testing.T, s *testScrubber) {
+			s.put(t, leaf, true)
+			s.blockIndex.Add(leaf.Cid(), 4, "queen", BlockOriginPush)
+		},
+		lost: map[string]string{leaf.Cid().String(): ScrubReasonHashMismatch},
+	}, {
+		name: "dag pinned recursively",
+		setup: func(t *testing.T, s *testScrubber) {
+			s.put(t, root, false)
+			s.put(t, leaf, true)
+			s.blockIndex.Add(root.Cid(), 16, "ant", BlockOriginDagMigration)
+		},
+		lost: map[string]string{
+			leaf.Cid().String():  ScrubReasonHashMismatch,
+			other.Cid().String(): ScrubReasonMissing,
+		},
+		roots: map[string]string{
+			leaf.Cid().String():  root.Cid().String(),
+			other.Cid().String(): root.Cid().String(),
+		},
+	}} {
+		s := newTestScrubber(t)
+		tc.setup(t, s)
+
+		// a block still lost in the next pass is not counted nor reported
+		// again
+		state := &ScrubState{}
+		for i := 0; i < 2; i++ {
+			if err := s.pass(context.Background(), state); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+		if state.Lost != uint64(len(tc.lost)) || len(s.reported) != len(tc.lost) {
+			t.Fatalf("%s: %d lost, %d reported, expected %d", tc.name, state.Lost, len(s.reported), len(tc.lost))
+		}
+		quarantined := s.quarantined(t)
+		if len(quarantined) != len(tc.lost) {
+			t.Fatalf("%s: %d blocks quarantined, expected %d", tc.name, len(quarantined), len(tc.lost))
+		}
+		for c, reason := range tc.lost {
+			record := quarantined[c]
+			if record == nil || record.Reason != reason || !record.Reported || record.Root != tc.roots[c] {
+				t.Fatalf("%s: unexpected quarantine record of %v: %+v", tc.name, c, record)
+			}
+		}
+	}
+}
+
+func TestScrubReleaseDag(t *testing.T) {
+	s := newTestScrubber(t)
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	root := merkledag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	s.put(t, root, false)
+	s.blockIndex.Add(root.Cid(), 16, "ant", BlockOriginDagMigration)
+	if err := s.pass(context.Background(), &ScrubState{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.quarantined(t)) != 1 {
+		t.Fatal("the missing block of the dag was not quarantined")
+	}
+	if err := s.release(root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if quarantined := s.quarantined(t); len(quarantined) != 0 {
+		t.Fatalf("%d blocks still quarantined", len(quarantined))
+	}
+}
@@ -0,0 +1,152 @@
+package mineservice
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/repo"
+	sysi "github.com/whyrusleeping/go-sysinfo"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// storageUsageTTL is how long the measured repo usage is trusted, the
+	// blocks admitted in the meantime are added to it.
+	storageUsageTTL = time.Minute
+)
+
+var (
+	ErrBlockTooLarge = errors.New("block exceeds the maximum block size")
+	ErrHashMismatch  = errors.New("block data does not match its cid")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// blockAdmission decides whether a pushed block may be stored.
+type blockAdmission struct {
+	repo          repo.Repo
+	quota         uint64
+	quotaFraction float64
+	maxBlockSize  uint64
+
+	lock    sync.Mutex
+	usage   uint64
+	limit   uint64
+	updated time.Time
+}
+
+func newBlockAdmission(cfg Storage, r repo.Repo) (*blockAdmission, error) {
+	a := &blockAdmission{
+		repo:          r,
+		quota:         math.MaxUint64,
+		quotaFraction: cfg.QuotaFraction,
+	}
+	if cfg.Quota != "" {
+		quota, err := humanize.ParseBytes(cfg.Quota)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage quota %q: %w", cfg.Quota, err)
+		}
+		a.quota = quota
+	}
+	if cfg.QuotaFraction < 0 || cfg.QuotaFraction > 1 {
+		return nil, fmt.Errorf("invalid storage quota fraction %v, must be between 0 and 1", cfg.QuotaFraction)
+	}
+	maxBlockSize, err := humanize.ParseBytes(cfg.MaxBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max block size %q: %w", cfg.MaxBlockSize, err)
+	}
+	a.maxBlockSize = maxBlockSize
+	return a, nil
+}
+
+// check verifies the size of the block and that its data hashes to its cid.
+func (a *blockAdmission) check(bcid cid.Cid, data []byte) error {
+	if uint64(len(data)) > a.maxBlockSize {
+		return ErrBlockTooLarge
+	}
+	sum, err := bcid.Prefix().Sum(data)
+	if err != nil || !sum.Equals(bcid) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// reserve accounts for a new block of the given size, it fails if the block
+// does not fit in the quota or if the storage usage cannot be measured.
+func (a *blockAdmission) reserve(size uint64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if time.Since(a.updated) > storageUsageTTL {
+		if err := a.refresh(); err != nil {
+			return fmt.Errorf("failed to get storage usage: %w", err)
+		}
+	}
+	if a.usage+size > a.limit {
+		return ErrQuotaExceeded
+	}
+	a.usage += size
+	return nil
+}
+
+// release gives back the reservation of a block which was not stored.
+func (a *blockAdmission) release(size uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if size > a.usage {
+		size = a.usage
+	}
+	a.usage -= size
+}
+
+// refresh measures the repo usage and computes the storage limit, which is
+// the quota or the quota fraction of the space available to the repo, its
+// usage plus the free disk space, whichever is lower.
+func (a *blockAdmission) refresh() error {
+	usage, err := a.repo.GetStorageUsage()
+	if err != nil {
+		return err
+	}
+	limit := a.quota
+	if a.quotaFraction > 0 {
+		free, err := a.diskFree()
+		if err != nil {
+			return err
+		}
+		fractionLimit := uint64(a.quotaFraction * float64(usage+free))
+		if fractionLimit < limit {
+			limit = fractionLimit
+		}
+	}
+	a.usage = usage
+	a.limit = limit
+	a.updated = time.Now()
+	return nil
+}
+
+func (a *blockAdmission) diskFree() (uint64, error) {
+	r, ok := a.repo.(interface{ Path() string })
+	if !ok {
+		return 0, errors.New("repo has no path")
+	}
+	stats, err := sysi.DiskUsage(r.Path())
+	if err != nil {
+		return 0, err
+	}
+	return stats.Free, nil
+}
+
+// usageAndLimit returns the measured repo usage and the storage limit, refreshed if
+// they are stale.
+func (a *blockAdmission) usageAndLimit() (uint64, uint64, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if time.Since(a.updated) > storageUsageTTL {
+		if err := a.refresh(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return a.usage, a.limit, nil
+}
@@ -0,0 +1,364 @@
+package mineservice
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookOutboxPrefix     = "/webhook/outbox/"
+	webhookDeadLetterPrefix = "/webhook/deadletter/"
+
+	maxWebhookDeadLetters = 1000
+	minWebhookBackoff     = time.Second
+	maxWebhookBackoff     = time.Minute
+
+	// EventWebhookTest is the type of the payload sent by TestWebhook, it is
+	// not published on the event bus.
+	EventWebhookTest = "webhook-test"
+)
+
+var (
+	ErrWebhookNotConfigured = errors.New("webhook is not configured, set Ant.Webhook.URL")
+)
+
+// DefaultWebhookEvents are the events sent when Ant.Webhook.Events is empty.
+var DefaultWebhookEvents = []string{
+	EventChequeReceived,
+	EventCashOutFinished,
+	EventBalanceLow,
+	EventPledgeMissing,
+	EventQueenUnreachable,
+	EventQuotaNearlyFull,
+}
+
+// WebhookPayload is the body POSTed to the webhook. The request carries the
+// X-Ant-Timestamp header, the unix time of the attempt, and the
+// X-Ant-Signature header, "sha256=" followed by the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the secret.
+type WebhookPayload struct {
+	Node string
+	Seq  uint64
+	Time time.Time
+	Type string
+	Data interface{}
+}
+
+// WebhookDeadLetter is a payload which could not be delivered.
+type WebhookDeadLetter struct {
+	Seq       uint64
+	Type      string
+	Payload   json.RawMessage
+	Attempts  int
+	LastError string
+	Failed    int64
+}
+
+// webhookOutboxEntry is a payload waiting to be delivered.
+type webhookOutboxEntry struct {
+	Seq     uint64
+	Type    string
+	Payload json.RawMessage
+}
+
+func WebhookOutboxKey(seq uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%020d", webhookOutboxPrefix, seq))
+}
+
+func WebhookDeadLetterKey(seq uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%020d", webhookDeadLetterPrefix, seq))
+}
+
+// webhookStatusError is a response of the webhook with a non 2xx status.
+type webhookStatusError struct {
+	code int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook answered %d %s", e.code, http.StatusText(e.code))
+}
+
+// permanent reports whether retrying cannot help, the webhook refused the
+// payload itself.
+func (e *webhookStatusError) permanent() bool {
+	return e.code >= 400 && e.code < 500 && e.code != http.StatusRequestTimeout &&
+		e.code != http.StatusTooManyRequests
+}
+
+// webhookNotifier POSTs the chosen events of the event bus to the webhook,
+// one at a time and in order. The events are saved in an outbox as they are
+// published and removed once delivered, so that none is lost when the node
+// stops or the notifier falls behind. A payload is retried with an
+// exponential backoff and recorded as a dead letter once the attempts are
+// exhausted.
+type webhookNotifier struct {
+	url         string
+	secret      []byte
+	node        string
+	types       []string
+	timeout     time.Duration
+	maxAttempts int
+	client      *http.Client
+	stateStore  statestore.StateStore
+	wake        chan struct{}
+}
+
+func newWebhookNotifier(cfg Webhook, node string, events *EventBus, stateStore statestore.StateStore) (*webhookNotifier, error) {
+	types := cfg.Events
+	if len(types) == 0 {
+		types = DefaultWebhookEvents
+	}
+	for _, t := range types {
+		if err := ValidEventType(t); err != nil {
+			return nil, fmt.Errorf("invalid webhook event: %w", err)
+		}
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultConfig().Webhook.MaxAttempts
+	}
+	w := &webhookNotifier{
+		url:         cfg.URL,
+		secret:      []byte(cfg.Secret),
+		node:        node,
+		types:       types,
+		timeout:     parseDuration(cfg.Timeout, 10*time.Second),
+		maxAttempts: maxAttempts,
+		client:      &http.Client{},
+		stateStore:  stateStore,
+		wake:        make(chan struct{}, 1),
+	}
+	if w.url != "" {
+		events.OnPublish(w.enqueue, types...)
+	}
+	return w, nil
+}
+
+// enqueue saves the event in the outbox.
+func (w *webhookNotifier) enqueue(event Event) {
+	body, err := w.payload(event)
+	if err != nil {
+		log.Errorf("failed to encode event %v: %v", event.Seq, err)
+		return
+	}
+	entry := &webhookOutboxEntry{
+		Seq:     event.Seq,
+		Type:    event.Type,
+		Payload: body,
+	}
+	if err := w.stateStore.Put(WebhookOutboxKey(event.Seq), entry); err != nil {
+		log.Errorf("failed to save event %v in the webhook outbox: %v", event.Seq, err)
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the events of the outbox, oldest first, until ctx is done.
+func (w *webhookNotifier) run(ctx context.Context) {
+	for {
+		entry, err := w.next()
+		if err != nil {
+			log.Errorf("failed to read the webhook outbox: %v", err)
+			if !sleep(ctx, maxWebhookBackoff) {
+				return
+			}
+			continue
+		}
+		if entry == nil {
+			select {
+			case <-w.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !w.deliver(ctx, entry) {
+			return
+		}
+		if err := w.stateStore.Delete(WebhookOutboxKey(entry.Seq)); err != nil {
+			log.Errorf("failed to remove event %v from the webhook outbox: %v", entry.Seq, err)
+			if !sleep(ctx, maxWebhookBackoff) {
+				return
+			}
+		}
+	}
+}
+
+// next returns the oldest event of the outbox, nil if it is empty. Entries
+// which cannot be decoded are removed.
+func (w *webhookNotifier) next() (*webhookOutboxEntry, error) {
+	var entry *webhookOutboxEntry
+	err := w.stateStore.Iterate(webhookOutboxPrefix, func(key string, value []byte) (stop bool, err error) {
+		e := webhookOutboxEntry{}
+		if err := json.Unmarshal(value, &e); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			if err := w.stateStore.Delete(datastore.NewKey(key)); err != nil {
+				return true, err
+			}
+			return false, nil
+		}
+		entry = &e
+		return true, nil
+	})
+	return entry, err
+}
+
+func (w *webhookNotifier) payload(event Event) ([]byte, error) {
+	return json.Marshal(&WebhookPayload{
+		Node: w.node,
+		Seq:  event.Seq,
+		Time: event.Time,
+		Type: event.Type,
+		Data: event.Data,
+	})
+}
+
+// deliver posts the event until it is delivered or recorded as a dead
+// letter, it returns false if ctx is done first.
+func (w *webhookNotifier) deliver(ctx context.Context, entry *webhookOutboxEntry) bool {
+	var err error
+	backoff := minWebhookBackoff
+	attempts := 0
+	for attempts < w.maxAttempts {
+		attempts++
+		err = w.post(ctx, entry.Type, entry.Seq, entry.Payload)
+		if err == nil {
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		var statusErr *webhookStatusError
+		if errors.As(err, &statusErr) && statusErr.permanent() {
+			break
+		}
+		if attempts == w.maxAttempts {
+			break
+		}
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		log.Debugf("failed to deliver event %v to the webhook, retrying in %v: %v", entry.Seq, backoff, err)
+		if !sleep(ctx, backoff) {
+			return false
+		}
+		backoff *= 2
+		if backoff > maxWebhookBackoff {
+			backoff = maxWebhookBackoff
+		}
+	}
+
+	webhookDeliveries.WithLabelValues("dead").Inc()
+	log.Errorf("failed to deliver event %v to the webhook after %v attempts: %v", entry.Seq, attempts, err)
+	letter := &WebhookDeadLetter{
+		Seq:       entry.Seq,
+		Type:      entry.Type,
+		Payload:   entry.Payload,
+		Attempts:  attempts,
+		LastError: err.Error(),
+		Failed:    time.Now().Unix(),
+	}
+	if err := w.stateStore.Put(WebhookDeadLetterKey(entry.Seq), letter); err != nil {
+		log.Errorf("failed to save dead letter of event %v: %v", entry.Seq, err)
+		return true
+	}
+	w.prune()
+	return true
+}
+
+// post makes a single delivery attempt.
+func (w *webhookNotifier) post(ctx context.Context, typ string, seq uint64, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ant-webhook")
+	req.Header.Set("X-Ant-Event", typ)
+	req.Header.Set("X-Ant-Delivery", strconv.FormatUint(seq, 10))
+	req.Header.Set("X-Ant-Timestamp", timestamp)
+	req.Header.Set("X-Ant-Signature", "sha256="+w.sign(timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookStatusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+func (w *webhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// test makes a single delivery attempt of a test payload.
+func (w *webhookNotifier) test(ctx context.Context) error {
+	if w.url == "" {
+		return ErrWebhookNotConfigured
+	}
+	event := Event{
+		Time: time.Now(),
+		Type: EventWebhookTest,
+	}
+	body, err := w.payload(event)
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, event.Type, event.Seq, body)
+}
+
+// DeadLetters returns the payloads which could not be delivered, oldest
+// first.
+func (w *webhookNotifier) DeadLetters() ([]*WebhookDeadLetter, error) {
+	var list []*WebhookDeadLetter
+	err := w.stateStore.Iterate(webhookDeadLetterPrefix, func(key string, value []byte) (stop bool, err error) {
+		letter := WebhookDeadLetter{}
+		if err := json.Unmarshal(value, &letter); err != nil {
+			log.Errorf("failed to Unmarshal: %v", err)
+			return false, err
+		}
+		list = append(list, &letter)
+		return false, nil
+	})
+	return list, err
+}
+
+// prune removes the oldest dead letters beyond maxWebhookDeadLetters.
+func (w *webhookNotifier) prune() {
+	list, err := w.DeadLetters()
+	if err != nil || len(list) <= maxWebhookDeadLetters {
+		return
+	}
+	for _, letter := range list[:len(list)-maxWebhookDeadLetters] {
+		if err := w.stateStore.Delete(WebhookDeadLetterKey(letter.Seq)); err != nil {
+			log.Errorf("failed to remove dead letter of event %v: %v", letter.Seq, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package mineservice
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs/core/mine/statestore"
+)
+
+const testWebhookSecret = "secret"
+
+// webhookServer answers the deliveries with the given status codes in turn,
+// the last one repeated, and records the requests.
+type webhookServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newWebhookServer(t *testing.T, codes ...int) *webhookServer {
+	s := &webhookServer{
+		codes:    codes,
+		received: make(chan struct{}, 16),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read the body: %v", err)
+		}
+		s.lock.Lock()
+		code := s.codes[0]
+		if len(s.codes) > 1 {
+			s.codes = s.codes[1:]
+		}
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		s.lock.Unlock()
+		w.WriteHeader(code)
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) attempts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.requests)
+}
+
+func newTestWebhookNotifier(t *testing.T, url string, maxAttempts int) (*webhookNotifier, *EventBus) {
+	stateStore := statestore.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	events, err := NewEventBus(0, stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWebhookNotifier(Webhook{
+		URL:         url,
+		Secret:      testWebhookSecret,
+		Timeout:     "5s",
+		MaxAttempts: maxAttempts,
+	}, "node", events, stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, events
+}
+
+func testOutboxEntry(t *testing.T, w *webhookNotifier) *webhookOutboxEntry {
+	body, err := w.payload(Event{Seq: 1, Time: time.Now(), Type: EventChequeReceived})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &webhookOutboxEntry{Seq: 1, Type: EventChequeReceived, Payload: body}
+}
+
+func deadLetters(t *testing.T, w *webhookNotifier) []*WebhookDeadLetter {
+	letters, err := w.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return letters
+}
+
+func TestWebhookSignature(t *testing.T) {
+	srv := newWebhookServer(t, http.StatusOK)
+	w, events := newTestWebhookNotifier(t, srv.URL, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.run(ctx)
+	}()
+
+	events.Publish(EventBlockPushed, &BlockPushedEvent{Cid: "ignored"})
+	events.Publish(EventChequeReceived, &ChequeReceivedEvent{Chequebook: "0x01"})
+	select {
+	case <-srv.received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the event was not delivered")
+	}
+
+	srv.lock.Lock()
+	req, body := srv.requests[0], srv.bodies[0]
+	srv.lock.Unlock()
+
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(req.Header.Get("X-Ant-Timestamp")))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if sig := req.Header.Get("X-Ant-Signature"); !hmac.Equal([]byte(sig), []byte(expected)) {
+		t.Fatalf("signature is %q, expected %q", sig, expected)
+	}
+	if typ := req.Header.Get("X-Ant-Event"); typ != EventChequeReceived {
+		t.Fatalf("event is %q, expected %q", typ, EventChequeReceived)
+	}
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Node != "node" || payload.Type != EventChequeReceived {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	// The event leaves the outbox once the response is read.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		entry, err := w.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("event %v is still in the outbox", entry.Seq)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := srv.attempts(); n != 1 {
+		t.Fatalf("%d deliveries, expected 1", n)
+	}
+	if letters := deadLetters(t, w); len(letters) != 0 {
+		t.Fatalf("%d dead letters, expected none", len(letters))
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		srv := newWebhookServer(t, code, http.StatusNoContent)
+		w, _ := newTestWebhookNotifier(t, srv.URL, 3)
+
+		if !w.deliver(context.Background(), testOutboxEntry(t, w)) {
+			t.Fatal("delivery was interrupted")
+		}
+		if n := srv.attempts(); n != 2 {
+			t.Fatalf("%d: %d attempts, expected 2", code, n)
+		}
+		if letters := deadLetters(t, w); len(letters) != 0 {
+			t.Fatalf("%d: %d dead letters, expected none", code, len(letters))
+		}
+	}
+}
+
+func TestWebhookNoRetry(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		srv := newWebhookServer(t, code, http.StatusOK)
+		w, _ := newTestWebhookNotifier(t, srv.URL, 3)
+
+		if !w.deliver(context.Background(), testOutboxEntry(t, w)) {
+			t.Fatal("delivery was interrupted")
+		}
+		if n := srv.attempts(); n != 1 {
+			t.Fatalf("%d: %d attempts, expected 1", code, n)
+		}
+		letters := deadLetters(t, w)
+		if len(letters) != 1 || letters[0].Attempts != 1 {
+			t.Fatalf("%d: unexpected dead letters %+v", code, letters)
+		}
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	srv := newWebhookServer(t, http.StatusInternalServerError)
+	w, _ := newTestWebhookNotifier(t, srv.URL, 3)
+
+	entry := testOutboxEntry(t, w)
+	start := time.Now()
+	if !w.deliver(context.Background(), entry) {
+		t.Fatal("delivery was interrupted")
+	}
+	if n := srv.attempts(); n != 3 {
+		t.Fatalf("%d attempts, expected 3", n)
+	}
+	// The attempts are minWebhookBackoff and twice minWebhookBackoff apart.
+	if elapsed := time.Since(start); elapsed < 3*minWebhookBackoff {
+		t.Fatalf("the attempts took %v, expected at least %v", elapsed, 3*minWebhookBackoff)
+	}
+
+	letters := deadLetters(t, w)
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters, expected 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.Seq != entry.Seq || letter.Type != entry.Type || letter.Attempts != 3 ||
+		string(letter.Payload) != string(entry.Payload) || letter.LastError == "" {
+		t.Fatalf("unexpected dead letter %+v", letter)
+	}
+}
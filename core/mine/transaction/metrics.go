@@ -0,0 +1,148 @@
+package transaction
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"math/big"
+)
+
+var (
+	rpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "chain",
+		Name:      "rpc_requests_total",
+		Help:      "Number of requests to the chain RPC endpoint by method.",
+	}, []string{"method"})
+
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ant",
+		Subsystem: "chain",
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed requests to the chain RPC endpoint by method.",
+	}, []string{"method"})
+)
+
+// instrumentedBackend counts the requests and the errors of a backend.
+type instrumentedBackend struct {
+	backend Backend
+}
+
+// NewInstrumentedBackend returns a backend which counts the requests to the
+// given backend and their errors. A missing receipt or transaction is not
+// counted as an error.
+func NewInstrumentedBackend(backend Backend) Backend {
+	return &instrumentedBackend{backend: backend}
+}
+
+func observe(method string, err error) {
+	rpcRequests.WithLabelValues(method).Inc()
+	if err != nil && err != ethereum.NotFound {
+		rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (b *instrumentedBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	code, err := b.backend.CodeAt(ctx, contract, blockNumber)
+	observe("CodeAt", err)
+	return code, err
+}
+
+func (b *instrumentedBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	result, err := b.backend.CallContract(ctx, call, blockNumber)
+	observe("CallContract", err)
+	return result, err
+}
+
+func (b *instrumentedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	header, err := b.backend.HeaderByNumber(ctx, number)
+	observe("HeaderByNumber", err)
+	return header, err
+}
+
+func (b *instrumentedBackend) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	code, err := b.backend.PendingCodeAt(ctx, account)
+	observe("PendingCodeAt", err)
+	return code, err
+}
+
+func (b *instrumentedBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	nonce, err := b.backend.PendingNonceAt(ctx, account)
+	observe("PendingNonceAt", err)
+	return nonce, err
+}
+
+func (b *instrumentedBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	price, err := b.backend.SuggestGasPrice(ctx)
+	observe("SuggestGasPrice", err)
+	return price, err
+}
+
+func (b *instrumentedBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	tip, err := b.backend.SuggestGasTipCap(ctx)
+	observe("SuggestGasTipCap", err)
+	return tip, err
+}
+
+func (b *instrumentedBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	gas, err := b.backend.EstimateGas(ctx, call)
+	observe("EstimateGas", err)
+	return gas, err
+}
+
+func (b *instrumentedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	err := b.backend.SendTransaction(ctx, tx)
+	observe("SendTransaction", err)
+	return err
+}
+
+func (b *instrumentedBackend) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	logs, err := b.backend.FilterLogs(ctx, query)
+	observe("FilterLogs", err)
+	return logs, err
+}
+
+func (b *instrumentedBackend) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	sub, err := b.backend.SubscribeFilterLogs(ctx, query, ch)
+	observe("SubscribeFilterLogs", err)
+	return sub, err
+}
+
+func (b *instrumentedBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	receipt, err := b.backend.TransactionReceipt(ctx, txHash)
+	observe("TransactionReceipt", err)
+	return receipt, err
+}
+
+func (b *instrumentedBackend) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, isPending, err := b.backend.TransactionByHash(ctx, hash)
+	observe("TransactionByHash", err)
+	return tx, isPending, err
+}
+
+func (b *instrumentedBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	number, err := b.backend.BlockNumber(ctx)
+	observe("BlockNumber", err)
+	return number, err
+}
+
+func (b *instrumentedBackend) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	block, err := b.backend.BlockByNumber(ctx, number)
+	observe("BlockByNumber", err)
+	return block, err
+}
+
+func (b *instrumentedBackend) BalanceAt(ctx context.Context, address common.Address, block *big.Int) (*big.Int, error) {
+	balance, err := b.backend.BalanceAt(ctx, address, block)
+	observe("BalanceAt", err)
+	return balance, err
+}
+
+func (b *instrumentedBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	nonce, err := b.backend.NonceAt(ctx, account, blockNumber)
+	observe("NonceAt", err)
+	return nonce, err
+}
@@ -20,6 +20,15 @@ func AntzFromRawString(str string) Antz {
 	return Antz(*ant)
 }
 
+// ParseAntz parses a decimal amount of ANTZ, e.g. "1.5", into raw units.
+func ParseAntz(str string) (*big.Int, error) {
+	d, err := decimal.NewFromString(str)
+	if err != nil {
+		return nil, err
+	}
+	return d.Shift(ANTZDecimal).BigInt(), nil
+}
+
 func (a Antz) String() string {
 	return a.Unitless() + " " + ANTZSymbol
 }
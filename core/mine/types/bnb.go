@@ -20,6 +20,15 @@ func NBNFromRawString(str string) BNB {
 	return BNB(*bnb)
 }
 
+// ParseBNB parses a decimal amount of BNB, e.g. "1.5", into raw units.
+func ParseBNB(str string) (*big.Int, error) {
+	d, err := decimal.NewFromString(str)
+	if err != nil {
+		return nil, err
+	}
+	return d.Shift(NBNDecimal).BigInt(), nil
+}
+
 func (a BNB) String() string {
 	return a.Unitless() + " " + NBNSymbol
 }
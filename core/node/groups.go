@@ -278,6 +278,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		LibP2P(bcfg, cfg),
 		OnlineProviders(cfg.Experimental.StrategicProviding, cfg.Experimental.AcceleratedDHTClient, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
 		fx.Provide(NewMineService),
+		fx.Invoke(RegisterChainMetrics),
 	)
 }
 
@@ -297,6 +298,7 @@ var Mining = fx.Options(
 	fx.Provide(NewLocalWallet),
 	fx.Provide(NewSigner),
 	fx.Provide(NewChain),
+	fx.Provide(NewMineEvents),
 	fx.Provide(NewChequeManager),
 )
 
@@ -2,6 +2,7 @@ package libp2p
 
 import (
 	"context"
+	"github.com/ipfs/go-ipfs/core/mine/mineproto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/antnest-network/ant-proto"
@@ -10,14 +11,17 @@ import (
 
 func Messenger(lc fx.Lifecycle, peerHost host.Host) proto.Messenger {
 	ctx, cancel := context.WithCancel(context.Background())
-	messenger := proto.NewAntMessenger(ctx, peerHost, []protocol.ID{
+	protocols := []protocol.ID{
 		proto.ProtocolPingMessage,
 		proto.ProtocolPongMessage,
 		proto.ProtocolPushBlockMessage,
 		proto.ProtocolMigrateBlockMessage,
 		proto.ProtocolCheque,
 		proto.ProtocolQueens,
-	})
+	}
+	protocols = append(protocols, mineproto.Protocols...)
+	mineproto.RegisterMessageTypes()
+	messenger := proto.NewAntMessenger(ctx, peerHost, protocols)
 
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
@@ -2,11 +2,14 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-graphsync"
 	"github.com/ipfs/go-ipfs-config"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	"github.com/ipfs/go-ipfs/core/mine/chain"
@@ -18,7 +21,9 @@ import (
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/antnest-network/ant-proto"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/fx"
+	"math/big"
 	"time"
 )
 
@@ -42,7 +47,10 @@ func NewSigner(w wallet.Wallet) crypto.Signer {
 	return crypto.NewWalletSigner(w)
 }
 
-func NewChain(signer crypto.Signer, stateStore statestore.StateStore, cfg *config.Config) (chain.Chain, error) {
+// NewChain returns the chain of the configured endpoints, which connects in
+// the background so that the node starts while the endpoints are down. The
+// endpoints must serve Ant.Chain.ChainID when it is set.
+func NewChain(lc fx.Lifecycle, signer crypto.Signer, stateStore statestore.StateStore, r repo.Repo, cfg *config.Config) (chain.Chain, error) {
 	ethAddress, err := signer.EthereumAddress()
 	if err != nil {
 		return nil, err
@@ -50,41 +58,174 @@ func NewChain(signer crypto.Signer, stateStore statestore.StateStore, cfg *confi
 	if !common.IsHexAddress(cfg.Ant.Chain.LockerContract) {
 		return nil, errors.New(fmt.Sprintf("LockerContract is error: %v", cfg.Ant.Chain.LockerContract))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
-	defer cancel()
-
-	ch, err := chain.NewChain(ctx, ethAddress, stateStore, signer, cfg.Ant.Chain.Endpoint, common.HexToAddress(cfg.Ant.Chain.LockerContract))
+	endpoints, err := chainEndpoints(r, cfg)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("failed to InitChain: %v", err))
+		return nil, err
+	}
+	var chainID *big.Int
+	var configuredChainID int64
+	if err := readAntConfigKey(r, "Ant.Chain.ChainID", &configuredChainID); err != nil {
+		return nil, err
 	}
+	if configuredChainID > 0 {
+		chainID = big.NewInt(configuredChainID)
+	}
+	ch := chain.NewChain(ethAddress, stateStore, signer, endpoints, chainID, common.HexToAddress(cfg.Ant.Chain.LockerContract))
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return ch.Close()
+		},
+	})
 	return ch, nil
 }
 
-func NewChequeManager(stateStore statestore.StateStore, chx chain.Chain) *mineservice.ChequeManager {
-	return mineservice.NewChequeManager(mineservice.NewChequeStore(stateStore), chx.TransactionService())
+// chainEndpoints returns Ant.Chain.Endpoint followed by the fallback
+// endpoints of Ant.Chain.Endpoints.
+func chainEndpoints(r repo.Repo, cfg *config.Config) ([]string, error) {
+	var fallbacks []string
+	if err := readAntConfigKey(r, "Ant.Chain.Endpoints", &fallbacks); err != nil {
+		return nil, err
+	}
+	var endpoints []string
+	seen := make(map[string]bool)
+	for _, endpoint := range append([]string{cfg.Ant.Chain.Endpoint}, fallbacks...) {
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
 }
 
-func NewMineService(lc fx.Lifecycle, h host.Host, messenger proto.Messenger, pinning pin.Pinner,
-	blockService blockservice.BlockService, signer crypto.Signer, chx chain.Chain,
-	stateStore statestore.StateStore, cfg *config.Config) (*mineservice.MineService, error) {
-	ethAddress, _ := signer.EthereumAddress()
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
-	defer cancel()
+// mineConfig reads the mine service settings from the Ant section of the
+// config file. They are not part of config.Ant, so they are read by key and
+// missing settings keep their defaults.
+func mineConfig(r repo.Repo) (mineservice.Config, error) {
+	mcfg := mineservice.DefaultConfig()
+	if err := readAntConfigKey(r, "Ant.CashOut", &mcfg.CashOut); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Authorization", &mcfg.Authorization); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Roster", &mcfg.Roster); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Storage", &mcfg.Storage); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Migration", &mcfg.Migration); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Scrub", &mcfg.Scrub); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Alerts", &mcfg.Alerts); err != nil {
+		return mcfg, err
+	}
+	if err := readAntConfigKey(r, "Ant.Webhook", &mcfg.Webhook); err != nil {
+		return mcfg, err
+	}
+	return mcfg, nil
+}
 
-	if !common.IsHexAddress(cfg.Ant.Chain.LockerContract) {
-		return nil, errors.New(fmt.Sprintf("LockerContract is error: %v", cfg.Ant.Chain.LockerContract))
+func readAntConfigKey(r repo.Repo, key string, v interface{}) error {
+	val, err := r.GetConfigKey(key)
+	if err != nil {
+		// the key is not set
+		return nil
 	}
-	err := chx.LockToken(ctx, cfg.Identity.PeerID, ethAddress)
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", key, err)
+	}
+	return nil
+}
+
+// RegisterChainMetrics serves the chain metrics of the wallet addresses from
+// the prometheus endpoint while the node runs.
+func RegisterChainMetrics(lc fx.Lifecycle, chx chain.Chain, w wallet.Wallet) {
+	collector := chain.NewCollector(chx, func() ([]common.Address, error) {
+		keys, err := w.List()
+		if err != nil {
+			return nil, err
+		}
+		addresses := make([]common.Address, 0, len(keys))
+		for _, key := range keys {
+			addresses = append(addresses, ethcrypto.PubkeyToAddress(key.PublicKey))
+		}
+		return addresses, nil
+	}, chain.DefaultScrapeCacheTTL)
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := prometheus.Register(collector); err != nil {
+				// another node of the process serves them already
+				logger.Warnf("chain metrics not registered: %v", err)
+				collector = nil
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if collector != nil {
+				prometheus.Unregister(collector)
+			}
+			return nil
+		},
+	})
+}
+
+// NewMineEvents returns the event bus shared by the mine service and the
+// cheque manager.
+func NewMineEvents(stateStore statestore.StateStore) (*mineservice.EventBus, error) {
+	return mineservice.NewEventBus(mineservice.DefaultEventRetention, stateStore)
+}
+
+func NewChequeManager(lc fx.Lifecycle, stateStore statestore.StateStore, chx chain.Chain,
+	events *mineservice.EventBus) (*mineservice.ChequeManager, error) {
+	chequeStore, err := mineservice.NewChequeStore(stateStore)
 	if err != nil {
 		return nil, err
 	}
+	m := mineservice.NewChequeManager(chequeStore, chx.Backend(), chx.TransactionService(), events)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return m.Close()
+		},
+	})
+	return m, nil
+}
+
+// mineGraphsync is the graphsync exchange, which is only provided with
+// Experimental.GraphsyncEnabled.
+type mineGraphsync struct {
+	fx.In
+
+	GraphExchange graphsync.GraphExchange `optional:"true"`
+}
+
+func NewMineService(lc fx.Lifecycle, h host.Host, messenger proto.Messenger, pinning pin.Pinner,
+	blockService blockservice.BlockService, gs mineGraphsync, signer crypto.Signer, chx chain.Chain, chequeManager *mineservice.ChequeManager,
+	events *mineservice.EventBus, stateStore statestore.StateStore, r repo.Repo, cfg *config.Config) (*mineservice.MineService, error) {
+	if !common.IsHexAddress(cfg.Ant.Chain.LockerContract) {
+		return nil, errors.New(fmt.Sprintf("LockerContract is error: %v", cfg.Ant.Chain.LockerContract))
+	}
 
 	queens, err := config.ParseBootstrapPeers(cfg.Ant.QueenAddresses)
 	if err != nil {
 		return nil, errors.New("failed to parse queen address")
 	}
-	ms := mineservice.New(h, messenger, pinning, blockService, stateStore, chx.TransactionService(), signer, queens)
+	mcfg, err := mineConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := mineservice.New(h, messenger, pinning, blockService, gs.GraphExchange, stateStore, r, chx, chequeManager, signer, events, queens, mcfg)
+	if err != nil {
+		return nil, err
+	}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			return ms.Start()